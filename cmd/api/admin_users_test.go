@@ -0,0 +1,248 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestListUsers(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	admin := ts.insertUser(t, "admin@example.com", "pa55word1234", true, "users:manage")
+	adminToken := ts.newToken(t, admin, data.ScopeAuthentication)
+
+	ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+	ts.insertUser(t, "bob@example.com", "pa55word1234", false)
+	ts.insertUser(t, "carol@example.org", "pa55word1234", false)
+
+	tests := []struct {
+		name      string
+		query     string
+		wantIDs   []int64
+		wantTotal int
+	}{
+		{name: "All", query: "", wantIDs: []int64{1, 2, 3, 4}, wantTotal: 4},
+		{name: "Email filter", query: "?email=EXAMPLE.COM", wantIDs: []int64{1, 2, 3}, wantTotal: 3},
+		{name: "Activated filter", query: "?activated=false", wantIDs: []int64{3, 4}, wantTotal: 2},
+		{name: "Sorted and paginated", query: "?sort=-email&page_size=2&page=2", wantIDs: []int64{2, 1}, wantTotal: 4},
+		{name: "No matches", query: "?email=nobody", wantIDs: []int64{}, wantTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodGet, "/v1/admin/users"+tt.query, adminToken, nil)
+			assertStatus(t, res, http.StatusOK)
+
+			var got struct {
+				Metadata data.Metadata `json:"metadata"`
+				Users    []struct {
+					ID      int64 `json:"id"`
+					Version int   `json:"version"`
+				} `json:"users"`
+			}
+			decodeJSON(t, res, &got)
+
+			if got.Users == nil {
+				t.Fatal("got null users; want an array")
+			}
+
+			ids := []int64{}
+			for _, user := range got.Users {
+				ids = append(ids, user.ID)
+				if user.Version != 1 {
+					t.Errorf("got version %d for user %d; want 1", user.Version, user.ID)
+				}
+			}
+
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("got ids %v; want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Fatalf("got ids %v; want %v", ids, tt.wantIDs)
+				}
+			}
+
+			if got.Metadata.TotalRecords != tt.wantTotal {
+				t.Errorf("got total records %d; want %d", got.Metadata.TotalRecords, tt.wantTotal)
+			}
+		})
+	}
+
+	t.Run("Invalid filters", func(t *testing.T) {
+		res := ts.request(t, http.MethodGet, "/v1/admin/users?activated=maybe&sort=password", adminToken, nil)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"activated": "must be a boolean value", "sort": "invalid sort value"}}`)
+	})
+}
+
+func TestManageUser(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	admin := ts.insertUser(t, "admin@example.com", "pa55word1234", true, "users:manage")
+	adminToken := ts.newToken(t, admin, data.ScopeAuthentication)
+
+	alice := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	aliceToken := ts.newToken(t, alice, data.ScopeAuthentication)
+
+	res := ts.request(t, http.MethodGet, "/v1/admin/users/2", aliceToken, nil)
+	assertStatus(t, res, http.StatusForbidden)
+
+	// Grant and revoke a permission.
+	res = ts.request(t, http.MethodPost, "/v1/admin/users/2/permissions", adminToken, map[string]any{"permissions": []string{"movies:read", "movies:write"}})
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"permissions": ["movies:read", "movies:write"]}`)
+
+	res = ts.request(t, http.MethodDelete, "/v1/admin/users/2/permissions/movies:write", adminToken, nil)
+	assertStatus(t, res, http.StatusOK)
+
+	res = ts.request(t, http.MethodDelete, "/v1/admin/users/2/permissions/movies:write", adminToken, nil)
+	assertStatus(t, res, http.StatusNotFound)
+
+	res = ts.request(t, http.MethodGet, "/v1/admin/users/2", adminToken, nil)
+	assertStatus(t, res, http.StatusOK)
+
+	var got struct {
+		User struct {
+			Email     string `json:"email"`
+			Activated bool   `json:"activated"`
+			Version   int    `json:"version"`
+		} `json:"user"`
+		Roles       []string `json:"roles"`
+		Permissions []string `json:"permissions"`
+	}
+	decodeJSON(t, res, &got)
+
+	if got.User.Email != "alice@example.com" || !got.User.Activated || got.User.Version != 1 {
+		t.Errorf("got user %+v; want activated alice@example.com at version 1", got.User)
+	}
+	if len(got.Permissions) != 1 || got.Permissions[0] != "movies:read" {
+		t.Errorf("got permissions %v; want [movies:read]", got.Permissions)
+	}
+
+	// Deactivating with a stale version is a conflict.
+	res = ts.request(t, http.MethodPatch, "/v1/admin/users/2", adminToken, map[string]any{"activated": false, "version": 7})
+	assertStatus(t, res, http.StatusConflict)
+
+	res = ts.request(t, http.MethodPatch, "/v1/admin/users/2", adminToken, map[string]any{"activated": false, "version": 1})
+	assertStatus(t, res, http.StatusOK)
+
+	decodeJSON(t, res, &got)
+	if got.User.Activated || got.User.Version != 2 {
+		t.Errorf("got user %+v; want deactivated at version 2", got.User)
+	}
+
+	// Deactivated users are logged out.
+	res = ts.request(t, http.MethodGet, "/v1/movies", aliceToken, nil)
+	assertStatus(t, res, http.StatusUnauthorized)
+
+	// Deleting also checks the version.
+	res = ts.request(t, http.MethodDelete, "/v1/admin/users/2?version=1", adminToken, nil)
+	assertStatus(t, res, http.StatusConflict)
+
+	res = ts.request(t, http.MethodDelete, "/v1/admin/users/2?version=2", adminToken, nil)
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"message": "user successfully deleted"}`)
+
+	res = ts.request(t, http.MethodGet, "/v1/admin/users/2", adminToken, nil)
+	assertStatus(t, res, http.StatusNotFound)
+
+	t.Run("Invalid input", func(t *testing.T) {
+		res := ts.request(t, http.MethodPatch, "/v1/admin/users/1", adminToken, map[string]any{})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"activated": "must be provided", "version": "must be provided"}}`)
+
+		res = ts.request(t, http.MethodDelete, "/v1/admin/users/1", adminToken, nil)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"version": "must be provided as an integer value"}}`)
+
+		res = ts.request(t, http.MethodPost, "/v1/admin/users/1/permissions", adminToken, map[string]any{"permissions": []string{"movies:delete"}})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"permissions": "must only contain known permission codes"}}`)
+	})
+}
+
+func TestDeactivatedUser(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	admin := ts.insertUser(t, "admin@example.com", "pa55word1234", true, "users:manage")
+	adminToken := ts.newToken(t, admin, data.ScopeAuthentication)
+
+	alice := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	aliceToken := ts.newToken(t, alice, data.ScopeAuthentication)
+
+	res := ts.request(t, http.MethodPost, "/v1/api-keys", aliceToken, map[string]any{"name": "nightly import", "permissions": []string{"movies:read"}})
+	assertStatus(t, res, http.StatusCreated)
+
+	var created struct {
+		APIKey struct {
+			Key string `json:"key"`
+		} `json:"api_key"`
+	}
+	decodeJSON(t, res, &created)
+
+	// An activation token left over from before the deactivation.
+	activationToken := ts.newToken(t, alice, data.ScopeActivation)
+
+	res = ts.request(t, http.MethodPatch, "/v1/admin/users/2", adminToken, map[string]any{"activated": false, "version": 1})
+	assertStatus(t, res, http.StatusOK)
+
+	var got struct {
+		User struct {
+			Activated     bool       `json:"activated"`
+			DeactivatedAt *time.Time `json:"deactivated_at"`
+		} `json:"user"`
+	}
+	decodeJSON(t, res, &got)
+
+	if got.User.Activated || got.User.DeactivatedAt == nil {
+		t.Errorf("got user %+v; want deactivated", got.User)
+	}
+
+	// The user can log in with their password, but can't use the session, their API
+	// keys or an old activation token.
+	res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+	assertStatus(t, res, http.StatusForbidden)
+	assertJSON(t, res, `{"error": "your user account has been deactivated"}`)
+
+	res = ts.request(t, http.MethodGet, "/v1/movies", ts.newToken(t, alice, data.ScopeAuthentication), nil)
+	assertStatus(t, res, http.StatusForbidden)
+	assertJSON(t, res, `{"error": "your user account has been deactivated"}`)
+
+	res = ts.request(t, http.MethodGet, "/v1/movies", created.APIKey.Key, nil)
+	assertStatus(t, res, http.StatusForbidden)
+	assertJSON(t, res, `{"error": "your user account has been deactivated"}`)
+
+	res = ts.request(t, http.MethodPut, "/v1/users/activated", "", map[string]any{"token": activationToken})
+	assertStatus(t, res, http.StatusForbidden)
+	assertJSON(t, res, `{"error": "your user account has been deactivated"}`)
+
+	// Reactivating the user lets them back in.
+	res = ts.request(t, http.MethodPatch, "/v1/admin/users/2", adminToken, map[string]any{"activated": true, "version": 2})
+	assertStatus(t, res, http.StatusOK)
+
+	got.User.DeactivatedAt = nil
+	decodeJSON(t, res, &got)
+	if !got.User.Activated || got.User.DeactivatedAt != nil {
+		t.Errorf("got user %+v; want reactivated", got.User)
+	}
+
+	res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+	assertStatus(t, res, http.StatusCreated)
+
+	res = ts.request(t, http.MethodGet, "/v1/movies", created.APIKey.Key, nil)
+	assertStatus(t, res, http.StatusOK)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	alice := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read", "movies:write")
+	aliceToken := ts.newToken(t, alice, data.ScopeAuthentication)
+
+	bob := ts.insertUser(t, "bob@example.com", "pa55word1234", true, "movies:read")
+	bobToken := ts.newToken(t, bob, data.ScopeAuthentication)
+
+	// Create a read-only key for Alice.
+	res := ts.request(t, http.MethodPost, "/v1/api-keys", aliceToken, map[string]any{"name": "nightly import", "permissions": []string{"movies:read"}})
+	assertStatus(t, res, http.StatusCreated)
+
+	var created struct {
+		APIKey struct {
+			ID  int64  `json:"id"`
+			Key string `json:"key"`
+		} `json:"api_key"`
+	}
+	decodeJSON(t, res, &created)
+
+	key := created.APIKey.Key
+	if !strings.HasPrefix(key, data.APIKeyPrefix) {
+		t.Fatalf("got key %q; want it to start with %q", key, data.APIKeyPrefix)
+	}
+
+	t.Run("Permissions", func(t *testing.T) {
+		res := ts.request(t, http.MethodGet, "/v1/movies", key, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		// Alice can write movies, but the key can't.
+		res = ts.request(t, http.MethodPost, "/v1/movies", key, map[string]any{})
+		assertStatus(t, res, http.StatusForbidden)
+
+		// API keys can't be used to manage credentials.
+		res = ts.request(t, http.MethodGet, "/v1/api-keys", key, nil)
+		assertStatus(t, res, http.StatusForbidden)
+		assertJSON(t, res, `{"error": "this resource can't be accessed with an API key"}`)
+
+		res = ts.request(t, http.MethodPost, "/v1/users/mfa/totp", key, nil)
+		assertStatus(t, res, http.StatusForbidden)
+
+		// Nor can they log the owner out.
+		res = ts.request(t, http.MethodDelete, "/v1/tokens", key, nil)
+		assertStatus(t, res, http.StatusForbidden)
+
+		res = ts.request(t, http.MethodDelete, "/v1/tokens/authentication", key, nil)
+		assertStatus(t, res, http.StatusForbidden)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		res := ts.request(t, http.MethodGet, "/v1/api-keys", aliceToken, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		var got struct {
+			APIKeys []struct {
+				ID          int64      `json:"id"`
+				Name        string     `json:"name"`
+				Permissions []string   `json:"permissions"`
+				LastUsedAt  *time.Time `json:"last_used_at"`
+				Key         string     `json:"key"`
+			} `json:"api_keys"`
+		}
+		decodeJSON(t, res, &got)
+
+		if len(got.APIKeys) != 1 {
+			t.Fatalf("got %d API keys; want 1", len(got.APIKeys))
+		}
+		if k := got.APIKeys[0]; k.ID != created.APIKey.ID || k.Name != "nightly import" || k.Key != "" || k.LastUsedAt == nil {
+			t.Errorf("got API key %+v; want the used key without its plaintext", k)
+		}
+
+		res = ts.request(t, http.MethodGet, "/v1/api-keys", bobToken, nil)
+		assertStatus(t, res, http.StatusOK)
+		assertJSON(t, res, `{"api_keys": []}`)
+	})
+
+	t.Run("Create invalid", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			body     map[string]any
+			wantBody string
+		}{
+			{
+				name:     "Missing fields",
+				body:     map[string]any{},
+				wantBody: `{"error": {"name": "must be provided", "permissions": "must contain at least 1 permission"}}`,
+			},
+			{
+				name:     "Permission the user doesn't have",
+				body:     map[string]any{"name": "admin", "permissions": []string{"users:manage"}},
+				wantBody: `{"error": {"permissions": "must only contain permission codes you have"}}`,
+			},
+			{
+				name:     "Duplicate name",
+				body:     map[string]any{"name": "nightly import", "permissions": []string{"movies:read"}},
+				wantBody: `{"error": {"name": "you already have an API key with this name"}}`,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res := ts.request(t, http.MethodPost, "/v1/api-keys", aliceToken, tt.body)
+				assertStatus(t, res, http.StatusUnprocessableEntity)
+				assertJSON(t, res, tt.wantBody)
+			})
+		}
+	})
+
+	t.Run("Owner loses permission", func(t *testing.T) {
+		res := ts.request(t, http.MethodPost, "/v1/api-keys", bobToken, map[string]any{"name": "reader", "permissions": []string{"movies:read"}})
+		assertStatus(t, res, http.StatusCreated)
+
+		var bobKey struct {
+			APIKey struct {
+				Key string `json:"key"`
+			} `json:"api_key"`
+		}
+		decodeJSON(t, res, &bobKey)
+
+		err := app.models.Permissions.RemoveForUser(context.Background(), bob.ID, "movies:read")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res = ts.request(t, http.MethodGet, "/v1/movies", bobKey.APIKey.Key, nil)
+		assertStatus(t, res, http.StatusForbidden)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		path := fmt.Sprintf("/v1/api-keys/%d", created.APIKey.ID)
+
+		// Only the owner can revoke a key.
+		res := ts.request(t, http.MethodDelete, path, bobToken, nil)
+		assertStatus(t, res, http.StatusNotFound)
+
+		res = ts.request(t, http.MethodDelete, path, aliceToken, nil)
+		assertStatus(t, res, http.StatusOK)
+		assertJSON(t, res, `{"message": "API key successfully revoked"}`)
+
+		res = ts.request(t, http.MethodGet, "/v1/movies", key, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = ts.request(t, http.MethodDelete, path, aliceToken, nil)
+		assertStatus(t, res, http.StatusNotFound)
+	})
+}
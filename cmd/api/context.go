@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/rynhndrcksn/greenlight/internal/data"
@@ -15,10 +16,60 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// accessTokenClaimsContextKey is the key for the claims of the signed access token the
+// request was authenticated with, if it was.
+const accessTokenClaimsContextKey = contextKey("access_token_claims")
+
+// apiKeyContextKey is the key for the API key the request was authenticated with, if it
+// was.
+const apiKeyContextKey = contextKey("api_key")
+
+// requestInfoContextKey is the key for the requestInfo for the request.
+const requestInfoContextKey = contextKey("request_info")
+
+// requestInfo holds the details about a request which are logged with it. The logRequest
+// middleware adds a pointer to one to the request context, so the middleware and
+// handlers further down the chain can fill in details (like the user) which it can't see.
+type requestInfo struct {
+	id     string
+	userID int64
+	route  string
+}
+
+// contextSetRequestInfo returns a new copy of the request with the provided requestInfo
+// added to the context.
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestInfo retrieves the requestInfo from a context, or returns nil if
+// there isn't one (like when the context isn't for a request).
+func contextGetRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
+
+// contextGetRequestID retrieves the ID of the request from the request context, or
+// returns the empty string if it doesn't have one.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	info := contextGetRequestInfo(r.Context())
+	if info == nil {
+		return ""
+	}
+
+	return info.id
+}
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// Record who made the request, so that it's included in the access log.
+	if info := contextGetRequestInfo(r.Context()); info != nil && !user.IsAnonymous() {
+		info.userID = user.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -34,3 +85,56 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetAccessTokenClaims returns a new copy of the request with the claims of the
+// signed access token it was authenticated with added to the context.
+func (app *application) contextSetAccessTokenClaims(r *http.Request, claims *data.AccessTokenClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), accessTokenClaimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetAccessTokenClaims retrieves the claims of the signed access token the request
+// was authenticated with, or returns nil if it wasn't authenticated with one.
+func (app *application) contextGetAccessTokenClaims(r *http.Request) *data.AccessTokenClaims {
+	claims, _ := r.Context().Value(accessTokenClaimsContextKey).(*data.AccessTokenClaims)
+	return claims
+}
+
+// contextSetAPIKey returns a new copy of the request with the API key it was
+// authenticated with added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey retrieves the API key the request was authenticated with, or returns
+// nil if it wasn't authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// loadUser returns the full record for the user making the request. Requests
+// authenticated with a signed access token only have the user's ID and activation state
+// in the context, so for them the user is looked up, and ErrRecordNotFound is returned
+// if they've since been deleted.
+func (app *application) loadUser(r *http.Request) (*data.User, error) {
+	user := app.contextGetUser(r)
+	if app.contextGetAccessTokenClaims(r) == nil {
+		return user, nil
+	}
+
+	return app.models.Users.Get(r.Context(), user.ID)
+}
+
+// loadUserErrorResponse sends the response for an error returned by loadUser. If the
+// user has been deleted since their access token was issued, the token is no longer
+// any good, so the client gets a 401 Unauthorized rather than a server error.
+func (app *application) loadUserErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.invalidAuthenticationTokenResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
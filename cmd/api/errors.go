@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/ratelimit"
 )
 
 // logError is a generic helper for logging error messages.
@@ -13,7 +17,7 @@ func (app *application) logError(r *http.Request, err error) {
 		uri    = r.URL.RequestURI()
 	)
 
-	app.logger.Error(err.Error(), slog.String("method", method), slog.String("uri", uri))
+	app.logger.ErrorContext(r.Context(), err.Error(), slog.String("method", method), slog.String("uri", uri))
 }
 
 // errorResponse is a generic helper for sending JSON formatted error messages to a client with a status code.
@@ -69,6 +73,27 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// mfaAlreadyEnabledResponse will send a 409 Conflict status code and JSON response to
+// the client.
+func (app *application) mfaAlreadyEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// unverifiedEmailResponse will send a 403 Forbidden status code and JSON response to the
+// client.
+func (app *application) unverifiedEmailResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the identity provider hasn't verified your email address"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// missingEmailResponse will send a 403 Forbidden status code and JSON response to the
+// client.
+func (app *application) missingEmailResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the identity provider didn't share a valid email address"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // rateLimitExceededResponse will send a 429 Too Many Requests status code and
 // JSON response to the client.
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
@@ -76,6 +101,14 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// tooManyLoginAttemptsResponse will send a 429 Too Many Requests status code and JSON
+// response to the client, with a Retry-After header saying when they can try again.
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(ratelimit.Seconds(retryAfter), 1)))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 // invalidCredentialsResponse will send a 401 Unauthorized status code and
 // JSON response to the client.
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
@@ -105,9 +138,23 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// deactivatedAccountResponse will send a 403 Forbidden status code and
+// JSON response to the client.
+func (app *application) deactivatedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been deactivated"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // notPermittedResponse will send a 403 Forbidden status code and
 // JSON response to the client.
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// apiKeyNotAllowedResponse will send a 403 Forbidden status code and JSON response to the
+// client.
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/mailer"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -20,3 +28,143 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// livenessHandler reports that the process is up and able to handle requests. It doesn't
+// check any dependencies, so that an orchestrator doesn't restart the server just
+// because the database is down.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dependencyCheck is the result of checking one of the dependencies for the readiness
+// probe. The latency is how long the check took. The error is logged rather than sent
+// to the client, as it can include details like the database's address.
+type dependencyCheck struct {
+	Status          string `json:"status"`
+	Latency         string `json:"latency"`
+	Error           string `json:"-"`
+	Version         *int64 `json:"version,omitempty"`
+	ExpectedVersion *int64 `json:"expected_version,omitempty"`
+}
+
+// readinessHandler reports whether the server is ready to receive traffic, by checking
+// that the database can be reached and has the expected schema, and optionally that the
+// SMTP server can be reached. It responds with a 503 Service Unavailable if any check
+// fails, or as soon as the server starts shutting down.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		err := app.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting down"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	checks := map[string]func(ctx context.Context) dependencyCheck{
+		"database":   app.checkDatabase,
+		"migrations": app.checkMigrations,
+	}
+	if app.config.health.smtp {
+		if pinger, ok := app.mailer.(mailer.Pinger); ok {
+			checks["smtp"] = func(context.Context) dependencyCheck { return checkSMTP(pinger) }
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.health.timeout)
+	defer cancel()
+
+	// Run the checks at the same time, so the probe takes as long as the slowest check
+	// rather than all of them.
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]dependencyCheck, len(checks))
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result := check(ctx)
+			if result.Error != "" {
+				app.logger.WarnContext(r.Context(), "readiness check failed", slog.String("dependency", name), slog.String("error", result.Error))
+			}
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	for _, result := range results {
+		if result.Status != "up" {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+
+	err := app.writeJSON(w, code, envelope{"status": status, "checks": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// timeCheck runs fn and returns a dependencyCheck with its outcome and latency.
+func timeCheck(fn func() error) dependencyCheck {
+	start := time.Now()
+	err := fn()
+
+	check := dependencyCheck{Status: "up", Latency: time.Since(start).String()}
+	if err != nil {
+		check.Status = "down"
+		check.Error = err.Error()
+	}
+
+	return check
+}
+
+// checkDatabase checks that a connection to the database can be made.
+func (app *application) checkDatabase(ctx context.Context) dependencyCheck {
+	return timeCheck(func() error {
+		return app.models.Health.Ping(ctx)
+	})
+}
+
+// checkMigrations checks that the database schema is at least the version this build
+// expects, and that the last migration didn't fail part way through. A newer schema is
+// fine, as migrations are applied before a new version of the server is rolled out.
+func (app *application) checkMigrations(ctx context.Context) dependencyCheck {
+	var version int64
+
+	check := timeCheck(func() error {
+		v, dirty, err := app.models.Health.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		version = v
+
+		switch {
+		case dirty:
+			return fmt.Errorf("migration %d is dirty", version)
+		case version < data.MigrationVersion:
+			return fmt.Errorf("schema version %d is older than %d", version, data.MigrationVersion)
+		}
+		return nil
+	})
+
+	expected := int64(data.MigrationVersion)
+	check.Version = &version
+	check.ExpectedVersion = &expected
+
+	return check
+}
+
+// checkSMTP checks that the SMTP server can be reached. The dialer has its own timeout,
+// so this doesn't take a context.
+func checkSMTP(pinger mailer.Pinger) dependencyCheck {
+	return timeCheck(pinger.Ping)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/mailer"
+)
+
+func TestHealthcheck(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	res := ts.request(t, http.MethodGet, "/v1/healthcheck", "", nil)
+
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{
+		"status": "available",
+		"system_info": {"environment": "testing", "version": "`+version+`"}
+	}`)
+
+	if ct := res.headers.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q; want %q", ct, "application/json")
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	res := ts.request(t, http.MethodGet, "/v1/healthz", "", nil)
+
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"status": "alive"}`)
+}
+
+// stubHealthStore is a data.HealthStore which reports whatever the test wants.
+type stubHealthStore struct {
+	pingErr error
+	version int64
+	dirty   bool
+}
+
+func (s stubHealthStore) Ping(ctx context.Context) error {
+	return s.pingErr
+}
+
+func (s stubHealthStore) SchemaVersion(ctx context.Context) (int64, bool, error) {
+	return s.version, s.dirty, nil
+}
+
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+
+	type readiness struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Status          string `json:"status"`
+			Latency         string `json:"latency"`
+			Version         int64  `json:"version"`
+			ExpectedVersion int64  `json:"expected_version"`
+		} `json:"checks"`
+	}
+
+	tests := []struct {
+		name       string
+		setup      func(app *application)
+		wantStatus int
+		wantChecks map[string]string
+	}{
+		{
+			name:       "Ready",
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"database": "up", "migrations": "up"},
+		},
+		{
+			name: "Newer schema",
+			setup: func(app *application) {
+				app.models.Health = stubHealthStore{version: data.MigrationVersion + 1}
+			},
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"database": "up", "migrations": "up"},
+		},
+		{
+			name: "Database down",
+			setup: func(app *application) {
+				app.models.Health = stubHealthStore{pingErr: errors.New("connection refused"), version: data.MigrationVersion}
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"database": "down", "migrations": "up"},
+		},
+		{
+			name: "Older schema",
+			setup: func(app *application) {
+				app.models.Health = stubHealthStore{version: data.MigrationVersion - 1}
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"database": "up", "migrations": "down"},
+		},
+		{
+			name: "Dirty schema",
+			setup: func(app *application) {
+				app.models.Health = stubHealthStore{version: data.MigrationVersion, dirty: true}
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"database": "up", "migrations": "down"},
+		},
+		{
+			name: "SMTP up",
+			setup: func(app *application) {
+				app.config.health.smtp = true
+			},
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"database": "up", "migrations": "up", "smtp": "up"},
+		},
+		{
+			name: "SMTP down",
+			setup: func(app *application) {
+				app.config.health.smtp = true
+				app.mailer.(*mailer.MemorySender).Fail(errors.New("dial tcp: i/o timeout"))
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"database": "up", "migrations": "up", "smtp": "down"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := newTestApplication(t)
+			if tt.setup != nil {
+				tt.setup(app)
+			}
+			ts := newTestServer(t, app)
+
+			res := ts.request(t, http.MethodGet, "/v1/readyz", "", nil)
+			assertStatus(t, res, tt.wantStatus)
+
+			var got readiness
+			decodeJSON(t, res, &got)
+
+			wantStatus := "ready"
+			if tt.wantStatus != http.StatusOK {
+				wantStatus = "not ready"
+			}
+			if got.Status != wantStatus {
+				t.Errorf("got status %q; want %q", got.Status, wantStatus)
+			}
+
+			if len(got.Checks) != len(tt.wantChecks) {
+				t.Errorf("got checks %v; want %v", got.Checks, tt.wantChecks)
+			}
+			for name, want := range tt.wantChecks {
+				check := got.Checks[name]
+				if check.Status != want {
+					t.Errorf("got %s status %q; want %q", name, check.Status, want)
+				}
+				if _, err := time.ParseDuration(check.Latency); err != nil {
+					t.Errorf("got %s latency %q; want a duration", name, check.Latency)
+				}
+			}
+
+			if got.Checks["migrations"].ExpectedVersion != data.MigrationVersion {
+				t.Errorf("got expected version %d; want %d", got.Checks["migrations"].ExpectedVersion, data.MigrationVersion)
+			}
+		})
+	}
+
+	t.Run("Shutting down", func(t *testing.T) {
+		t.Parallel()
+
+		app := newTestApplication(t)
+		app.shuttingDown.Store(true)
+		ts := newTestServer(t, app)
+
+		res := ts.request(t, http.MethodGet, "/v1/readyz", "", nil)
+		assertStatus(t, res, http.StatusServiceUnavailable)
+		assertJSON(t, res, `{"status": "shutting down"}`)
+
+		// The process is still alive until it has finished shutting down.
+		res = ts.request(t, http.MethodGet, "/v1/healthz", "", nil)
+		assertStatus(t, res, http.StatusOK)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -171,6 +172,76 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool is a helper method for returning an optional boolean from a query string.
+// It returns nil if the key isn't present.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readAcceptLanguage returns the language tags from the request's Accept-Language
+// header, most preferred first. Tags with a quality of zero, and the "*" wildcard, are
+// left out, as are any with a quality value which doesn't parse.
+func (app *application) readAcceptLanguage(r *http.Request) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	var languages []language
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if quality > 0 {
+			languages = append(languages, language{tag: tag, quality: quality})
+		}
+	}
+
+	// Use a stable sort, so tags with the same quality stay in the order they were sent.
+	slices.SortStableFunc(languages, func(a, b language) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	tags := make([]string, len(languages))
+	for i, l := range languages {
+		tags[i] = l.tag
+	}
+
+	return tags
+}
+
 // background helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tomasen/realip"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+const (
+	// loginFreeFailures is the number of failed logins for an account a client can
+	// retry straight away, so people who mistype their password aren't held up. After
+	// that the client must wait loginBaseDelay before trying again, doubling with each
+	// further failure up to loginMaxDelay, until the account is locked out.
+	loginFreeFailures = 2
+	loginBaseDelay    = time.Second
+	loginMaxDelay     = time.Minute
+
+	// loginMaxLockout is the longest an account or IP address is locked out for. The
+	// lockout doubles each time it's locked again within the failure window.
+	loginMaxLockout = 24 * time.Hour
+
+	// loginFailurePruneInterval is how often the failed logins which have dropped out of
+	// the failure window are deleted.
+	loginFailurePruneInterval = 10 * time.Minute
+)
+
+// loginAccountKey returns the key the failed logins for an email address are tracked
+// under. Failures are tracked by email address rather than user ID so that unknown
+// addresses are treated just like real accounts, and can't be told apart by whether
+// they get locked out.
+func loginAccountKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// loginIPKey returns the key the failed logins from the client's IP address are
+// tracked under.
+func loginIPKey(r *http.Request) string {
+	return "ip:" + realip.FromRequest(r)
+}
+
+// loginDelay returns how long a client must wait after the given number of failed
+// logins for an account before trying again.
+func loginDelay(failures int) time.Duration {
+	if failures <= loginFreeFailures {
+		return 0
+	}
+
+	delay := loginBaseDelay
+	for i := loginFreeFailures + 1; i < failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, loginMaxDelay)
+}
+
+// loginLockout returns how long to lock out an account or IP address for, given how many
+// times it has been locked out already within the failure window.
+func (app *application) loginLockout(previousLockouts int) time.Duration {
+	lockout := app.config.login.lockout
+	for i := 0; i < previousLockouts && lockout < loginMaxLockout; i++ {
+		lockout *= 2
+	}
+
+	return min(lockout, loginMaxLockout)
+}
+
+// loginRetryAfter returns how long the client must wait before it can try logging in to
+// the account with the email address, or zero if it can try now. Clients must wait if
+// the account or their IP address is locked out, or if they're trying again too soon
+// after a failed login for the account.
+func (app *application) loginRetryAfter(r *http.Request, email string) (time.Duration, error) {
+	now := time.Now()
+
+	account, err := app.models.LoginFailures.Get(r.Context(), loginAccountKey(email))
+	if err != nil {
+		return 0, err
+	}
+
+	ip, err := app.models.LoginFailures.Get(r.Context(), loginIPKey(r))
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+
+	for _, f := range []*data.LoginFailures{account, ip} {
+		if f.Locked(now) {
+			wait = max(wait, f.LockedUntil.Sub(now))
+		}
+	}
+
+	if account.Failures > 0 && account.Failures < app.config.login.maxFailures {
+		notBefore := account.LastFailureAt.Add(loginDelay(account.Failures))
+		wait = max(wait, notBefore.Sub(now))
+	}
+
+	return wait, nil
+}
+
+// recordLoginFailure records a failed login for the account with the email address and
+// for the client's IP address, and locks out either of them if they've had too many.
+// The user is nil if there's no account with the email address. Otherwise, the owner
+// of the account is sent an email to let them know when it's locked out.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User) error {
+	ctx := r.Context()
+	window := app.config.login.window
+
+	account, err := app.models.LoginFailures.RecordFailure(ctx, loginAccountKey(email), window)
+	if err != nil {
+		return err
+	}
+
+	if account.Failures >= app.config.login.maxFailures {
+		lockout := app.loginLockout(account.Failures - app.config.login.maxFailures)
+		until := time.Now().Add(lockout)
+
+		err = app.models.Transaction(ctx, func(tx data.Models) error {
+			err := tx.LoginFailures.Lock(ctx, account.Key, until)
+			if err != nil || user == nil {
+				return err
+			}
+
+			return tx.Outbox.Enqueue(ctx, &data.Email{
+				Recipient: user.Email,
+				Locale:    user.PreferredLocale,
+				Template:  "account_locked.tmpl",
+				Data: map[string]any{
+					"name":        user.Name,
+					"failures":    account.Failures,
+					"lockedUntil": until.UTC().Format(time.RFC1123),
+				},
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		app.wakeOutbox()
+	}
+
+	ip, err := app.models.LoginFailures.RecordFailure(ctx, loginIPKey(r), window)
+	if err != nil {
+		return err
+	}
+
+	if ip.Failures >= app.config.login.ipMaxFailures {
+		lockout := app.loginLockout(ip.Failures - app.config.login.ipMaxFailures)
+		return app.models.LoginFailures.Lock(ctx, ip.Key, time.Now().Add(lockout))
+	}
+
+	return nil
+}
+
+// resetLoginFailures forgets the failed logins for the account with the email address,
+// after a successful login, a password reset or when an admin unlocks the account. The failures for IP
+// addresses are left alone, so that an attacker can't reset them by logging in to an
+// account of their own.
+func (app *application) resetLoginFailures(ctx context.Context, email string) error {
+	return app.models.LoginFailures.Reset(ctx, loginAccountKey(email))
+}
+
+// failedLoginResponse records a failed login and sends a 401 Unauthorized response.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, email string, user *data.User) {
+	err := app.recordLoginFailure(r, email, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// runLoginFailurePruner deletes the failed logins which have dropped out of the failure
+// window every prune interval, until ctx is cancelled. Only successful logins reset the
+// failures for an account, so this is what forgets IP addresses, and email addresses
+// which never log in.
+func (app *application) runLoginFailurePruner(ctx context.Context) {
+	ticker := time.NewTicker(loginFailurePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := app.models.LoginFailures.Prune(ctx, time.Now().Add(-app.config.login.window))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			app.logger.Error("pruning failed logins", slog.String("error", err.Error()))
+		case deleted > 0:
+			app.logger.Info("pruned failed logins", slog.Int64("deleted", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
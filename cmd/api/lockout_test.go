@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	login := func(t *testing.T, ts *testServer, email, password string) testResponse {
+		t.Helper()
+		return ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": email, "password": password})
+	}
+
+	t.Run("Progressive delays", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+
+		// The first few failures can be retried straight away...
+		for range loginFreeFailures + 1 {
+			res := login(t, ts, "alice@example.com", "wrongpa55word")
+			assertStatus(t, res, http.StatusUnauthorized)
+		}
+
+		// ...but after that the client has to wait, even with the right password.
+		res := login(t, ts, "alice@example.com", "pa55word1234")
+		assertStatus(t, res, http.StatusTooManyRequests)
+		assertJSON(t, res, `{"error": "too many failed login attempts, please try again later"}`)
+		assertHeaders(t, res, map[string]string{"Retry-After": "1"})
+	})
+
+	t.Run("Account lockout", func(t *testing.T) {
+		t.Parallel()
+
+		app := newTestApplication(t)
+		app.config.login.maxFailures = 2
+		ts := newTestServer(t, app)
+
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		admin := ts.insertUser(t, "admin@example.com", "pa55word1234", true, "users:manage")
+		adminToken := ts.newToken(t, admin, data.ScopeAuthentication)
+
+		for range 2 {
+			res := login(t, ts, "Alice@Example.com", "wrongpa55word")
+			assertStatus(t, res, http.StatusUnauthorized)
+		}
+
+		// The account is locked out, even with the right password.
+		res := login(t, ts, "alice@example.com", "pa55word1234")
+		assertStatus(t, res, http.StatusTooManyRequests)
+
+		retryAfter, err := strconv.Atoi(res.headers.Get("Retry-After"))
+		if err != nil || retryAfter < 14*60 || retryAfter > 15*60 {
+			t.Errorf("got Retry-After %q; want about 15 minutes", res.headers.Get("Retry-After"))
+		}
+
+		// The owner of the account is told about it.
+		emails := sentEmails(app)
+		if len(emails) != 1 {
+			t.Fatalf("got %d emails; want 1", len(emails))
+		}
+		if emails[0].Recipient != user.Email || emails[0].Template != "account_locked.tmpl" {
+			t.Errorf("got email %q to %q; want %q to %q", emails[0].Template, emails[0].Recipient, "account_locked.tmpl", user.Email)
+		}
+		if got := fmt.Sprint(emailData(emails[0], "failures")); got != "2" {
+			t.Errorf("got failures %s; want 2", got)
+		}
+
+		// Other accounts aren't affected.
+		res = login(t, ts, "admin@example.com", "pa55word1234")
+		assertStatus(t, res, http.StatusCreated)
+
+		// Until an admin unlocks the account.
+		res = ts.request(t, http.MethodPost, fmt.Sprintf("/v1/admin/users/%d/unlock", user.ID), adminToken, nil)
+		assertStatus(t, res, http.StatusOK)
+		assertJSON(t, res, `{"message": "user successfully unlocked"}`)
+
+		res = login(t, ts, "alice@example.com", "pa55word1234")
+		assertStatus(t, res, http.StatusCreated)
+	})
+
+	t.Run("Unknown accounts", func(t *testing.T) {
+		t.Parallel()
+
+		app := newTestApplication(t)
+		app.config.login.maxFailures = 2
+		ts := newTestServer(t, app)
+
+		// Unknown email addresses are locked out just like real accounts, so they
+		// can't be told apart, but there's nobody to email.
+		for range 2 {
+			res := login(t, ts, "nobody@example.com", "wrongpa55word")
+			assertStatus(t, res, http.StatusUnauthorized)
+		}
+
+		res := login(t, ts, "nobody@example.com", "wrongpa55word")
+		assertStatus(t, res, http.StatusTooManyRequests)
+
+		if emails := sentEmails(app); len(emails) != 0 {
+			t.Errorf("got %d emails; want none", len(emails))
+		}
+	})
+
+	t.Run("IP lockout", func(t *testing.T) {
+		t.Parallel()
+
+		app := newTestApplication(t)
+		app.config.login.ipMaxFailures = 3
+		ts := newTestServer(t, app)
+
+		ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+
+		// Failures for different accounts from the same address add up.
+		for i := range 3 {
+			res := login(t, ts, fmt.Sprintf("user%d@example.com", i), "wrongpa55word")
+			assertStatus(t, res, http.StatusUnauthorized)
+		}
+
+		res := login(t, ts, "alice@example.com", "pa55word1234")
+		assertStatus(t, res, http.StatusTooManyRequests)
+	})
+
+	t.Run("Successful login resets failures", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+
+		for range 2 {
+			for range loginFreeFailures {
+				res := login(t, ts, "alice@example.com", "wrongpa55word")
+				assertStatus(t, res, http.StatusUnauthorized)
+			}
+
+			res := login(t, ts, "alice@example.com", "pa55word1234")
+			assertStatus(t, res, http.StatusCreated)
+		}
+	})
+
+	t.Run("Unlock unknown user", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		admin := ts.insertUser(t, "admin@example.com", "pa55word1234", true, "users:manage")
+		adminToken := ts.newToken(t, admin, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodPost, "/v1/admin/users/999/unlock", adminToken, nil)
+		assertStatus(t, res, http.StatusNotFound)
+	})
+}
+
+func TestLoginDelays(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+
+	delays := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for failures, want := range delays {
+		if got := loginDelay(failures); got != want {
+			t.Errorf("got delay %s after %d failures; want %s", got, failures, want)
+		}
+	}
+	if got := loginDelay(100); got != loginMaxDelay {
+		t.Errorf("got delay %s after 100 failures; want %s", got, loginMaxDelay)
+	}
+
+	lockouts := []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour}
+	for previous, want := range lockouts {
+		if got := app.loginLockout(previous); got != want {
+			t.Errorf("got lockout %s after %d lockouts; want %s", got, previous, want)
+		}
+	}
+	if got := app.loginLockout(100); got != loginMaxLockout {
+		t.Errorf("got lockout %s after 100 lockouts; want %s", got, loginMaxLockout)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/tomasen/realip"
+)
+
+// requestIDRX matches the request IDs we accept from clients in the X-Request-ID header.
+// Anything else is replaced, so clients can't put arbitrary text in our logs.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// contextHandler is a slog.Handler which adds the ID of the request to every record
+// logged with a request context, so all the logs for a request can be found together.
+type contextHandler struct {
+	slog.Handler
+}
+
+// newContextHandler returns a contextHandler which passes records on to h.
+func newContextHandler(h slog.Handler) contextHandler {
+	return contextHandler{Handler: h}
+}
+
+// Handle adds the request ID (if there is one) to the record and passes it on.
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := contextGetRequestInfo(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a new contextHandler whose wrapped handler has the given attributes.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a new contextHandler whose wrapped handler has the given group.
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// newRequestID returns a new random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+
+	// rand.Read() never returns an error on the platforms we support.
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// logRequest assigns every request an ID, sends it back in the X-Request-ID header, and
+// logs a line for each request once it has been handled. If the client (or a proxy in
+// front of us) sends a valid X-Request-ID header, that ID is used instead, so requests
+// can be traced across services.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			id = newRequestID()
+		}
+
+		info := &requestInfo{id: id}
+		r = app.contextSetRequestInfo(r, info)
+		w.Header().Set("X-Request-ID", id)
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("uri", r.URL.RequestURI()),
+			slog.Int("status", mw.statusCode),
+			slog.Int("bytes", mw.bytesWritten),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", realip.FromRequest(r)),
+		}
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int64("user_id", info.userID))
+		}
+
+		app.logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+// newLogRecorder replaces the application's logger with one which writes JSON records
+// to the returned buffer.
+func newLogRecorder(app *application) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	app.logger = slog.New(newContextHandler(slog.NewJSONHandler(buf, nil)))
+	return buf
+}
+
+// decodeLogs decodes each of the JSON records in buf.
+func decodeLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]any
+		err := dec.Decode(&record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestLogRequest(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("something went wrong")
+
+	app := newTestApplication(t)
+	logs := newLogRecorder(app)
+
+	user := &data.User{ID: 42}
+
+	handler := app.logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetUser(r, user)
+
+		switch r.URL.Path {
+		case "/error":
+			app.serverErrorResponse(w, r, errTest)
+		default:
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("hello"))
+		}
+	}))
+
+	t.Run("Access log", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/teapot?x=1", nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("X-Request-ID"); got != "abc-123" {
+			t.Errorf("got X-Request-ID %q; want %q", got, "abc-123")
+		}
+
+		records := decodeLogs(t, logs)
+		if len(records) != 1 {
+			t.Fatalf("got %d log records; want 1", len(records))
+		}
+
+		want := map[string]any{
+			"msg":        "request",
+			"request_id": "abc-123",
+			"method":     "GET",
+			"uri":        "/teapot?x=1",
+			"status":     418.0,
+			"bytes":      5.0,
+			"ip":         "192.0.2.1",
+			"user_id":    42.0,
+		}
+		for key, value := range want {
+			if records[0][key] != value {
+				t.Errorf("got %s %v; want %v", key, records[0][key], value)
+			}
+		}
+		if _, ok := records[0]["duration"]; !ok {
+			t.Error("log record is missing the duration")
+		}
+	})
+
+	t.Run("Invalid request ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", "not valid\nat all")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		got := rr.Header().Get("X-Request-ID")
+		if !requestIDRX.MatchString(got) || got == req.Header.Get("X-Request-ID") {
+			t.Errorf("got X-Request-ID %q; want a newly generated ID", got)
+		}
+
+		decodeLogs(t, logs)
+	})
+
+	t.Run("Errors include the request ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/error", nil)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		id := rr.Header().Get("X-Request-ID")
+
+		records := decodeLogs(t, logs)
+		if len(records) != 2 {
+			t.Fatalf("got %d log records; want 2", len(records))
+		}
+		for _, record := range records {
+			if record["request_id"] != id {
+				t.Errorf("got log record %v; want request_id %q", record, id)
+			}
+		}
+		if records[0]["msg"] != errTest.Error() || records[1]["status"] != 500.0 {
+			t.Errorf("got log records %v; want the error followed by the request", records)
+		}
+	})
+}
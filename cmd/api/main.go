@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"expvar"
 	"flag"
@@ -11,12 +12,15 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
 
 	"github.com/rynhndrcksn/greenlight/internal/data"
 	"github.com/rynhndrcksn/greenlight/internal/mailer"
+	"github.com/rynhndrcksn/greenlight/internal/oidc"
+	"github.com/rynhndrcksn/greenlight/internal/ratelimit"
 	"github.com/rynhndrcksn/greenlight/internal/vcs"
 )
 
@@ -27,15 +31,26 @@ type config struct {
 	port int
 	env  string
 	db   struct {
+		backend      string
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		queryTimeout time.Duration
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		backend  string
+		rps      float64
+		burst    int
+		enabled  bool
+		policies map[string]ratelimit.Policy
+
+		// authPolicy limits the requests with invalid credentials from each IP address.
+		authPolicy ratelimit.Policy
+	}
+	mailer struct {
+		backend string
+		dir     string
 	}
 	smtp struct {
 		host     string
@@ -47,15 +62,68 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	cursor struct {
+		secret []byte
+	}
+	accessTokens struct {
+		format string
+		ttl    time.Duration
+		keys   *data.AccessTokenKeys
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+	cache struct {
+		size int
+		ttl  time.Duration
+	}
+	ops struct {
+		host     string
+		port     int
+		username string
+		password string
+	}
+	outbox struct {
+		pollInterval time.Duration
+		maxAttempts  int
+		retention    time.Duration
+	}
+	health struct {
+		timeout time.Duration
+		smtp    bool
+	}
+	login struct {
+		maxFailures   int
+		ipMaxFailures int
+		lockout       time.Duration
+		window        time.Duration
+	}
 }
 
 // Application struct that contains stuff we will want to use throughout our project.
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	models  data.Models
+	mailer  mailer.Sender
+	limiter ratelimit.Limiter
+	wg      sync.WaitGroup
+
+	// oidc is the OpenID Connect provider users can log in with, or nil if none has
+	// been configured.
+	oidc *oidc.Provider
+
+	prometheus *prometheusMetrics
+
+	// outboxWake is used to wake up the outbox worker when emails are queued.
+	outboxWake chan struct{}
+
+	// shuttingDown is set once graceful shutdown begins, so the readiness probe can
+	// tell load balancers to stop sending us traffic.
+	shuttingDown atomic.Bool
 }
 
 func main() {
@@ -63,18 +131,58 @@ func main() {
 	var conf config
 	flag.IntVar(&conf.port, "port", 4000, "API server port")
 	flag.StringVar(&conf.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&conf.db.backend, "db-backend", "postgres", "Storage backend (postgres|memory)")
 	flag.StringVar(&conf.db.dsn, "dsn", os.Getenv("GREENLIGHT_DB_DSN"), "Database DSN")
 	flag.IntVar(&conf.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&conf.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.DurationVar(&conf.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	flag.DurationVar(&conf.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL query timeout")
+	flag.IntVar(&conf.cache.size, "cache-size", 10_000, "Maximum number of users and permission sets to cache")
+	flag.DurationVar(&conf.cache.ttl, "cache-ttl", 30*time.Second, "Time to cache users and permissions for (0 disables caching)")
 	flag.Float64Var(&conf.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&conf.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&conf.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.StringVar(&conf.limiter.backend, "limiter-backend", "memory", "Rate limiter backend (memory|postgres)")
+	// Start with the default policies for the routes which need stricter limits, and
+	// let the "-limiter-policy" flag override them or add more.
+	conf.limiter.policies = make(map[string]ratelimit.Policy)
+	for _, policy := range defaultRoutePolicies {
+		conf.limiter.policies[policy.Name] = policy
+	}
+	flag.Func("limiter-policy", "Rate limit policy for a route, like \"POST /v1/tokens/authentication=10/1m\" (can be repeated)", func(val string) error {
+		route, policy, err := parseRoutePolicy(val)
+		if err != nil {
+			return err
+		}
+		conf.limiter.policies[route] = policy
+		return nil
+	})
+	conf.limiter.authPolicy = defaultAuthenticationPolicy
+	flag.Func("limiter-auth-policy", "Rate limit policy for requests with invalid credentials from each IP address, checked before the credentials are looked up (default \"60/1m\")", func(val string) error {
+		policy, err := ratelimit.ParsePolicy(val)
+		if err != nil {
+			return err
+		}
+		policy.Name = defaultAuthenticationPolicy.Name
+		conf.limiter.authPolicy = policy
+		return nil
+	})
+	flag.StringVar(&conf.mailer.backend, "mailer", "smtp", "Email backend (smtp|file|memory)")
+	flag.StringVar(&conf.mailer.dir, "mailer-dir", "./tmp/emails", "Directory the file email backend writes .eml files to")
 	flag.StringVar(&conf.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&conf.smtp.port, "smtp-port", 2525, "SMTP port")
 	flag.StringVar(&conf.smtp.username, "smtp-username", os.Getenv("GREENLIGHT_SMTP_USER"), "SMTP username")
 	flag.StringVar(&conf.smtp.password, "smtp-password", os.Getenv("GREENLIGHT_SMTP_PASS"), "SMTP password")
 	flag.StringVar(&conf.smtp.sender, "smtp-sender", "Greenlight <no-reply@domain.com>", "SMTP sender")
+	flag.DurationVar(&conf.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "How often to check the email outbox for emails to send")
+	flag.IntVar(&conf.outbox.maxAttempts, "outbox-max-attempts", 8, "Maximum number of attempts at sending an email before giving up")
+	flag.DurationVar(&conf.outbox.retention, "outbox-retention", 7*24*time.Hour, "How long to keep sent and dead emails in the outbox before deleting them")
+	flag.IntVar(&conf.login.maxFailures, "login-max-failures", 5, "Failed logins for an account before it's locked out")
+	flag.IntVar(&conf.login.ipMaxFailures, "login-ip-max-failures", 50, "Failed logins from an IP address before it's locked out")
+	flag.DurationVar(&conf.login.lockout, "login-lockout", 15*time.Minute, "How long to lock out accounts and IP addresses for after too many failed logins (doubling each time they're locked out again)")
+	flag.DurationVar(&conf.login.window, "login-failure-window", time.Hour, "How long failed logins are remembered for after the last one")
+	flag.DurationVar(&conf.health.timeout, "health-timeout", 2*time.Second, "Maximum time the readiness probe waits for its dependency checks")
+	flag.BoolVar(&conf.health.smtp, "health-check-smtp", false, "Check that the SMTP server can be reached in the readiness probe")
 	// Use the flag.Func() function to process the "-cors-trusted-origins" command line flag.
 	// In this, we use the strings.Fields() function to split the flag value into a
 	// slice based on whitespace characters and assign it to our config struct.
@@ -84,6 +192,18 @@ func main() {
 		conf.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	flag.IntVar(&conf.ops.port, "ops-port", 0, "Port for the ops server, which serves metrics, expvar, pprof and health checks without authentication (0 serves them on the API port instead, behind the ops credentials)")
+	flag.StringVar(&conf.ops.host, "ops-host", "127.0.0.1", "Interface for the ops server to listen on. It isn't authenticated, so only use a public interface if the port is firewalled")
+	flag.StringVar(&conf.ops.username, "ops-username", "ops", "Basic auth username for the ops endpoints when they're served on the API port")
+	flag.StringVar(&conf.ops.password, "ops-password", os.Getenv("GREENLIGHT_OPS_PASS"), "Basic auth password for the ops endpoints when they're served on the API port (empty disables basic auth)")
+	flag.StringVar(&conf.accessTokens.format, "access-token-format", "opaque", "Format of the authentication tokens issued at login (opaque|jwt)")
+	flag.DurationVar(&conf.accessTokens.ttl, "access-token-ttl", 15*time.Minute, "How long jwt authentication tokens are valid for (they can't be revoked before they expire)")
+	accessTokenKeys := flag.String("access-token-keys", os.Getenv("GREENLIGHT_ACCESS_TOKEN_KEYS"), "Keys for signing jwt authentication tokens, as space separated id=secret pairs with the signing key first")
+	flag.StringVar(&conf.oidc.issuer, "oidc-issuer", "", "Issuer URL of the OpenID Connect provider users can log in with (empty disables OpenID Connect logins)")
+	flag.StringVar(&conf.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&conf.oidc.clientSecret, "oidc-client-secret", os.Getenv("GREENLIGHT_OIDC_CLIENT_SECRET"), "OpenID Connect client secret (empty for public clients)")
+	flag.StringVar(&conf.oidc.redirectURL, "oidc-redirect-url", "", "URL the OpenID Connect provider sends users back to after they log in")
+	cursorSecret := flag.String("cursor-secret", os.Getenv("GREENLIGHT_CURSOR_SECRET"), "Secret key for signing pagination cursors")
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 	flag.Parse()
 
@@ -92,17 +212,60 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Initialize a new structured logger that writes to stdout.
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// Initialize a new structured logger that writes to stdout. Records logged with a
+	// request's context include the ID of the request.
+	logger := slog.New(newContextHandler(slog.NewTextHandler(os.Stdout, nil)))
 
-	// Initialize a new db connection
-	db, err := openDB(conf)
-	if err != nil {
-		logger.Error(err.Error())
+	// If no secret was provided for signing pagination cursors, generate a random one.
+	// This works fine for a single instance, but cursors will stop working when the
+	// server restarts and won't be accepted by any other instances.
+	conf.cursor.secret = []byte(*cursorSecret)
+	if len(conf.cursor.secret) == 0 {
+		conf.cursor.secret = make([]byte, 32)
+		_, err := rand.Read(conf.cursor.secret)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Warn("no cursor secret provided, using a random one")
+	}
+
+	// Load the keys for signing authentication tokens if they're going to be JWTs. As
+	// with the cursor secret, if none were provided we generate a random one.
+	switch conf.accessTokens.format {
+	case "opaque":
+	case "jwt":
+		var err error
+		if *accessTokenKeys != "" {
+			conf.accessTokens.keys, err = data.ParseAccessTokenKeys(*accessTokenKeys)
+		} else {
+			conf.accessTokens.keys, err = data.RandomAccessTokenKeys()
+			logger.Warn("no access token keys provided, using a random one")
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	default:
+		logger.Error("invalid access token format", slog.String("format", conf.accessTokens.format))
 		os.Exit(1)
 	}
-	defer db.Close()
-	logger.Info("database connection pool established")
+
+	// Set up logging in with an OpenID Connect provider, if one has been configured.
+	var oidcProvider *oidc.Provider
+	if conf.oidc.issuer != "" {
+		if conf.oidc.clientID == "" || conf.oidc.redirectURL == "" {
+			logger.Error("OpenID Connect logins need -oidc-client-id and -oidc-redirect-url")
+			os.Exit(1)
+		}
+
+		oidcProvider = oidc.New(oidc.Config{
+			Issuer:       conf.oidc.issuer,
+			ClientID:     conf.oidc.clientID,
+			ClientSecret: conf.oidc.clientSecret,
+			RedirectURL:  conf.oidc.redirectURL,
+		})
+	}
 
 	// Publish a new "version" variable in the expvar handler containing our application's version number.
 	expvar.NewString("version").Set(version)
@@ -112,11 +275,115 @@ func main() {
 		return runtime.NumGoroutine()
 	}))
 
-	// Publish the database connection pool statistics.
-	expvar.Publish("database", expvar.Func(func() any {
-		return db.Stats()
+	// Initialize the metrics served to Prometheus.
+	prometheus := newPrometheusMetrics()
+
+	// Initialize the models using the configured storage backend. The db is only set
+	// for the postgres backend.
+	var (
+		models data.Models
+		db     *sql.DB
+	)
+	switch conf.db.backend {
+	case "postgres":
+		// Initialize a new db connection
+		var err error
+		db, err = openDB(conf)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer db.Close()
+		logger.Info("database connection pool established")
+
+		// Publish the database connection pool statistics.
+		expvar.Publish("database", expvar.Func(func() any {
+			return db.Stats()
+		}))
+
+		prometheus.registerDBStats(db)
+
+		models = data.NewModels(db, conf.db.queryTimeout)
+	case "memory":
+		// The in-memory backend keeps everything in the process, so it's only suitable
+		// for local demos and tests.
+		logger.Warn("using in-memory storage, all data will be lost when the server stops")
+		models = data.NewMemoryModels()
+	default:
+		logger.Error("invalid storage backend", slog.String("backend", conf.db.backend))
+		os.Exit(1)
+	}
+
+	// Cache the user and permission lookups made on every authenticated request, and
+	// publish the hit and miss counters so we can see how effective the caches are.
+	if conf.cache.ttl > 0 {
+		caches := data.NewCaches(conf.cache.size, conf.cache.ttl)
+		models = caches.Wrap(models)
+
+		expvar.Publish("cache", expvar.Func(func() any {
+			return map[string]any{
+				"users":       caches.Users.Stats(),
+				"permissions": caches.Permissions.Stats(),
+			}
+		}))
+	}
+
+	// Publish the number of emails in the outbox in each state, so we can keep an eye on
+	// how many are waiting to be sent and how many we've given up on.
+	expvar.Publish("email_outbox", expvar.Func(func() any {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		counts, err := models.Outbox.CountByStatus(ctx)
+		if err != nil {
+			return err.Error()
+		}
+		return counts
 	}))
 
+	// Initialize the configured email backend.
+	var sender mailer.Sender
+	switch conf.mailer.backend {
+	case "smtp":
+		sender = mailer.NewSMTPSender(conf.smtp.host, conf.smtp.port, conf.smtp.username, conf.smtp.password, conf.smtp.sender)
+	case "file":
+		fileSender, err := mailer.NewFileSender(conf.mailer.dir, conf.smtp.sender)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("writing emails to files", slog.String("dir", conf.mailer.dir))
+		sender = fileSender
+	case "memory":
+		// The in-memory backend just holds on to the emails, so nobody will ever see them.
+		logger.Warn("using in-memory email backend, emails will not be delivered")
+		sender = mailer.NewMemorySender()
+	default:
+		logger.Error("invalid email backend", slog.String("backend", conf.mailer.backend))
+		os.Exit(1)
+	}
+
+	// Initialize the configured rate limiter backend.
+	if conf.limiter.enabled && (conf.limiter.rps <= 0 || conf.limiter.burst < 1) {
+		logger.Error("the rate limiter needs a positive -limiter-rps and -limiter-burst")
+		os.Exit(1)
+	}
+
+	var limiter ratelimit.Limiter
+	switch conf.limiter.backend {
+	case "memory":
+		limiter = ratelimit.NewMemoryLimiter()
+	case "postgres":
+		if db == nil {
+			logger.Error("the postgres rate limiter needs the postgres storage backend")
+			os.Exit(1)
+		}
+		limiter = ratelimit.NewPostgresLimiter(db, conf.db.queryTimeout)
+	default:
+		logger.Error("invalid rate limiter backend", slog.String("backend", conf.limiter.backend))
+		os.Exit(1)
+	}
+
 	// Publish the current Unix timestamp.
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
@@ -124,13 +391,18 @@ func main() {
 
 	// Initialize a new application.
 	app := &application{
-		config: conf,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(conf.smtp.host, conf.smtp.port, conf.smtp.username, conf.smtp.password, conf.smtp.sender),
+		config:  conf,
+		logger:  logger,
+		models:  models,
+		mailer:  sender,
+		limiter: limiter,
+		oidc:    oidcProvider,
+
+		prometheus: prometheus,
+		outboxWake: make(chan struct{}, 1),
 	}
 
-	err = app.serve()
+	err := app.serve()
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
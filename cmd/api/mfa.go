@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/totp"
+	"github.com/rynhndrcksn/greenlight/internal/validator"
+)
+
+const (
+	// mfaIssuer is the name authenticator apps show for our accounts.
+	mfaIssuer = "Greenlight"
+
+	// mfaPendingTTL is how long a user has to enter their code after entering their
+	// password, before they have to start logging in again.
+	mfaPendingTTL = 5 * time.Minute
+)
+
+// mfaEnabled reports whether the user has two-factor authentication enabled.
+func (app *application) mfaEnabled(ctx context.Context, userID int64) (bool, error) {
+	mfa, err := app.models.MFA.Get(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return mfa.Enabled(), nil
+}
+
+// mfaRequiredResponse sends a short-lived mfa-pending token to a user with two-factor
+// authentication enabled who has passed the first step of logging in, with a 202
+// Accepted status code. The client exchanges the token along with a code at
+// POST /v1/tokens/mfa for the session tokens.
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request, userID int64) {
+	mfaToken, err := app.models.Tokens.New(r.Context(), userID, mfaPendingTTL, data.ScopeMFAPending)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_required": true, "mfa_token": mfaToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyMFACode checks a TOTP code or a recovery code (whichever was given) for the user,
+// using it up if it's valid. TOTP codes can't be used twice, and nor can recovery codes.
+func (app *application) verifyMFACode(ctx context.Context, mfa *data.MFA, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		err := app.models.MFA.UseRecoveryCode(ctx, mfa.UserID, recoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	step, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Refuse a code for a step we've already accepted one for, so a code which has
+	// been seen by someone else can't be replayed.
+	err := app.models.MFA.UseStep(ctx, mfa.UserID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// beginMFAHandler starts enrolling the user in TOTP two-factor authentication. It
+// generates a new secret and returns it along with an otpauth:// URL, which the client
+// can show as a QR code for the user to scan into their authenticator app. Two-factor
+// authentication isn't enabled until the user confirms they've set it up by sending a
+// code to confirmMFAHandler.
+func (app *application) beginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.loadUser(r)
+	if err != nil {
+		app.loadUserErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Begin(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.mfaAlreadyEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"totp": map[string]string{
+		"secret":      secret,
+		"otpauth_url": totp.URL(mfaIssuer, user.Email, secret),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmMFAHandler finishes enrolling the user, once they've sent a valid code from
+// their authenticator app. It returns the user's recovery codes, which are only stored
+// hashed, so this is the only time they can be seen.
+func (app *application) confirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMFACode(v, input.Code, ""); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	mfa, err := app.models.MFA.Get(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor authentication enrolment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled() {
+		app.mfaAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Enable(r.Context(), user.ID, step, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.mfaAlreadyEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableMFAHandler turns off two-factor authentication for the user. They must send a
+// valid code (or recovery code), so that someone who has only got hold of one of their
+// authentication tokens can't turn it off. Invalid codes count as failed logins.
+func (app *application) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMFACode(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.loadUser(r)
+	if err != nil {
+		app.loadUserErrorResponse(w, r, err)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if mfa == nil || !mfa.Enabled() {
+		v.AddError("code", "two-factor authentication is not enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	retryAfter, err := app.loginRetryAfter(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	ok, err := app.verifyMFACode(r.Context(), mfa, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.MFA.Disable(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler is the second step of logging in for users with
+// two-factor authentication enabled. It exchanges the mfa-pending token returned by
+// createAuthenticationTokenHandler, along with a valid code or recovery code, for an
+// authentication token and refresh token. Invalid codes count as failed logins, so the
+// account is locked out after too many.
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	// The mfa-pending token has the same format as every other token, but we report
+	// any problems against the "mfa_token" key.
+	if data.ValidateTokenPlaintext(v, input.MFAToken); !v.Valid() {
+		app.failedValidationResponse(w, r, map[string]string{"mfa_token": v.Errors["token"]})
+		return
+	}
+
+	if data.ValidateMFACode(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa_token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The user could have been deactivated since they entered their password.
+	if user.Deactivated() {
+		app.deactivatedAccountResponse(w, r)
+		return
+	}
+
+	retryAfter, err := app.loginRetryAfter(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	// If two-factor authentication has been turned off since the password was checked,
+	// the client has to log in again.
+	mfa, err := app.models.MFA.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if mfa == nil || !mfa.Enabled() {
+		v.AddError("mfa_token", "invalid or expired mfa token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.verifyMFACode(r.Context(), mfa, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.failedLoginResponse(w, r, user.Email, user)
+		return
+	}
+
+	// Each mfa-pending token can only be exchanged once. If a concurrent request got
+	// there first, this one loses.
+	err = app.models.Tokens.Delete(r.Context(), data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa_token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.resetLoginFailures(r.Context(), user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env, err := app.createSessionTokens(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/totp"
+)
+
+// enableMFA enrols the user in two-factor authentication through the API, and returns
+// their secret and recovery codes.
+func enableMFA(t *testing.T, ts *testServer, token string) (string, []string) {
+	t.Helper()
+
+	res := ts.request(t, http.MethodPost, "/v1/users/mfa/totp", token, nil)
+	assertStatus(t, res, http.StatusCreated)
+
+	var begin struct {
+		TOTP struct {
+			Secret     string `json:"secret"`
+			OTPAuthURL string `json:"otpauth_url"`
+		} `json:"totp"`
+	}
+	decodeJSON(t, res, &begin)
+
+	if begin.TOTP.OTPAuthURL != totp.URL(mfaIssuer, "alice@example.com", begin.TOTP.Secret) {
+		t.Errorf("got otpauth_url %q for secret %q", begin.TOTP.OTPAuthURL, begin.TOTP.Secret)
+	}
+
+	res = ts.request(t, http.MethodPut, "/v1/users/mfa/totp", token, map[string]any{"code": mfaCode(t, begin.TOTP.Secret, 0)})
+	assertStatus(t, res, http.StatusOK)
+
+	var confirm struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decodeJSON(t, res, &confirm)
+
+	if len(confirm.RecoveryCodes) != data.RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(confirm.RecoveryCodes), data.RecoveryCodeCount)
+	}
+
+	return begin.TOTP.Secret, confirm.RecoveryCodes
+}
+
+// mfaCode returns the TOTP code for the secret, the given number of periods from now.
+// Each code can only be used once, so tests use later periods for later codes.
+func mfaCode(t *testing.T, secret string, periods int) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, time.Now().Add(time.Duration(periods)*totp.Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+// mfaLogin logs in with a password, checks that a code is required, and returns the
+// mfa-pending token.
+func mfaLogin(t *testing.T, ts *testServer) string {
+	t.Helper()
+
+	res := ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+	assertStatus(t, res, http.StatusAccepted)
+
+	var body struct {
+		MFARequired bool `json:"mfa_required"`
+		MFAToken    struct {
+			Token string `json:"token"`
+		} `json:"mfa_token"`
+	}
+	decodeJSON(t, res, &body)
+
+	if !body.MFARequired || body.MFAToken.Token == "" {
+		t.Fatalf("got mfa_required %t and mfa_token %q; want a token", body.MFARequired, body.MFAToken.Token)
+	}
+
+	return body.MFAToken.Token
+}
+
+func TestMFAEnrolment(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Enable and disable", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		secret, recoveryCodes := enableMFA(t, ts, token)
+
+		// It can't be enrolled in twice.
+		res := ts.request(t, http.MethodPost, "/v1/users/mfa/totp", token, nil)
+		assertStatus(t, res, http.StatusConflict)
+		assertJSON(t, res, `{"error": "two-factor authentication is already enabled"}`)
+
+		// Disabling it needs a valid code, and the code used to enable it can't be reused.
+		res = ts.request(t, http.MethodDelete, "/v1/users/mfa/totp", token, map[string]any{"code": mfaCode(t, secret, 0)})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"code": "invalid code"}}`)
+
+		res = ts.request(t, http.MethodDelete, "/v1/users/mfa/totp", token, map[string]any{"recovery_code": recoveryCodes[0]})
+		assertStatus(t, res, http.StatusOK)
+
+		// Logging in only needs the password again.
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusCreated)
+	})
+
+	t.Run("Invalid confirmation code", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodPut, "/v1/users/mfa/totp", token, map[string]any{"code": "123456"})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"code": "two-factor authentication enrolment has not been started"}}`)
+
+		res = ts.request(t, http.MethodPost, "/v1/users/mfa/totp", token, nil)
+		assertStatus(t, res, http.StatusCreated)
+
+		for _, code := range []string{"", "12345", "abcdef"} {
+			res = ts.request(t, http.MethodPut, "/v1/users/mfa/totp", token, map[string]any{"code": code})
+			assertStatus(t, res, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Requires activated user", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", false)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodPost, "/v1/users/mfa/totp", token, nil)
+		assertStatus(t, res, http.StatusForbidden)
+	})
+}
+
+func TestMFALogin(t *testing.T) {
+	t.Parallel()
+
+	t.Run("TOTP code", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		secret, _ := enableMFA(t, ts, ts.newToken(t, user, data.ScopeAuthentication))
+
+		mfaToken := mfaLogin(t, ts)
+
+		// The mfa-pending token can't be used to authenticate requests.
+		res := ts.request(t, http.MethodPost, "/v1/users/mfa/totp", mfaToken, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": mfaCode(t, secret, 1)})
+		assertStatus(t, res, http.StatusCreated)
+
+		var body struct {
+			AuthenticationToken struct {
+				Token string `json:"token"`
+			} `json:"authentication_token"`
+		}
+		decodeJSON(t, res, &body)
+
+		res = ts.request(t, http.MethodPost, "/v1/users/mfa/totp", body.AuthenticationToken.Token, nil)
+		assertStatus(t, res, http.StatusConflict)
+
+		// Each mfa-pending token can only be exchanged once.
+		res = ts.request(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": mfaCode(t, secret, 1)})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"mfa_token": "invalid or expired mfa token"}}`)
+	})
+
+	t.Run("Recovery code", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		_, recoveryCodes := enableMFA(t, ts, ts.newToken(t, user, data.ScopeAuthentication))
+
+		res := ts.request(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{"mfa_token": mfaLogin(t, ts), "recovery_code": recoveryCodes[0]})
+		assertStatus(t, res, http.StatusCreated)
+
+		// Each recovery code can only be used once.
+		res = ts.request(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{"mfa_token": mfaLogin(t, ts), "recovery_code": recoveryCodes[0]})
+		assertStatus(t, res, http.StatusUnauthorized)
+	})
+
+	t.Run("Invalid codes count as failed logins", func(t *testing.T) {
+		t.Parallel()
+
+		app := newTestApplication(t)
+		app.config.login.maxFailures = 2
+		ts := newTestServer(t, app)
+
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		secret, _ := enableMFA(t, ts, ts.newToken(t, user, data.ScopeAuthentication))
+
+		mfaToken := mfaLogin(t, ts)
+
+		for range 2 {
+			res := ts.request(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": "000000"})
+			assertStatus(t, res, http.StatusUnauthorized)
+			assertJSON(t, res, `{"error": "invalid authentication credentials"}`)
+		}
+
+		// The account is locked out, even with the right code, and a correct password
+		// doesn't lift the lockout.
+		res := ts.request(t, http.MethodPost, "/v1/tokens/mfa", "", map[string]any{"mfa_token": mfaToken, "code": mfaCode(t, secret, 1)})
+		assertStatus(t, res, http.StatusTooManyRequests)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusTooManyRequests)
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		authToken := ts.newToken(t, user, data.ScopeAuthentication)
+		enableMFA(t, ts, authToken)
+
+		tests := []struct {
+			name     string
+			body     map[string]any
+			wantBody string
+		}{
+			{
+				name:     "Missing code",
+				body:     map[string]any{"mfa_token": mfaLogin(t, ts)},
+				wantBody: `{"error": {"code": "must be provided"}}`,
+			},
+			{
+				name:     "Code and recovery code",
+				body:     map[string]any{"mfa_token": mfaLogin(t, ts), "code": "123456", "recovery_code": "AAAA-AAAA-AAAA-AAAA"},
+				wantBody: `{"error": {"code": "must not be provided with a recovery code"}}`,
+			},
+			{
+				name:     "Malformed token",
+				body:     map[string]any{"mfa_token": "abc", "code": "123456"},
+				wantBody: `{"error": {"mfa_token": "must be 26 bytes long"}}`,
+			},
+			{
+				name:     "Wrong scope",
+				body:     map[string]any{"mfa_token": authToken, "code": "123456"},
+				wantBody: `{"error": {"mfa_token": "invalid or expired mfa token"}}`,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res := ts.request(t, http.MethodPost, "/v1/tokens/mfa", "", tt.body)
+				assertStatus(t, res, http.StatusUnprocessableEntity)
+				assertJSON(t, res, tt.wantBody)
+			})
+		}
+	})
+}
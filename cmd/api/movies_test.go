@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+// racingMovieStore wraps a MovieStore and updates every movie it reads behind the
+// caller's back, simulating another client saving the same movie concurrently.
+type racingMovieStore struct {
+	data.MovieStore
+}
+
+func (s racingMovieStore) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	movie, err := s.MovieStore.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrent := *movie
+	err = s.MovieStore.Update(ctx, &concurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	return movie, nil
+}
+
+func TestCreateMovie(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	writer := ts.insertUser(t, "writer@example.com", "pa55word1234", true, "movies:read", "movies:write")
+	token := ts.newToken(t, writer, data.ScopeAuthentication)
+
+	t.Run("Valid", func(t *testing.T) {
+		body := map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation", "adventure"}}
+		res := ts.request(t, http.MethodPost, "/v1/movies", token, body)
+
+		assertStatus(t, res, http.StatusCreated)
+		assertJSON(t, res, `{"movie": {
+			"id": 1,
+			"title": "Moana",
+			"year": 2016,
+			"runtime": "107 mins",
+			"genres": ["animation", "adventure"],
+			"version": 1
+		}}`)
+
+		if got := res.headers.Get("Location"); got != "/v1/movies/1" {
+			t.Errorf("got Location %q; want %q", got, "/v1/movies/1")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		body := map[string]any{"title": "", "year": 1000, "runtime": "-1 mins", "genres": []string{"drama", "drama"}}
+		res := ts.request(t, http.MethodPost, "/v1/movies", token, body)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {
+			"title": "must be provided",
+			"year": "must be greater than 1888",
+			"runtime": "must be a positive integer",
+			"genres": "must not contain duplicate values"
+		}}`)
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantBody string
+	}{
+		{"Empty body", ``, `{"error": "body must not be empty"}`},
+		{"Badly-formed JSON", `{"title": "Moana", }`, `{"error": "body contains badly-formed JSON (at character 20)"}`},
+		{"Unexpected EOF", `{"title": "Moana"`, `{"error": "body contains badly-formed JSON"}`},
+		{"Wrong type", `{"title": 123}`, `{"error": "body contains incorrect JSON type for field \"title\""}`},
+		{"Unknown key", `{"rating": "PG"}`, `{"error": "body contains unknown key \"rating\""}`},
+		{"Multiple values", `{"title": "Moana"}{"title": "Up"}`, `{"error": "body must only contain a single JSON value"}`},
+		{"Bad runtime", `{"runtime": "107 minutes"}`, `{"error": "invalid runtime format"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodPost, "/v1/movies", token, tt.body)
+
+			assertStatus(t, res, http.StatusBadRequest)
+			assertJSON(t, res, tt.wantBody)
+		})
+	}
+}
+
+func TestShowMovie(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	reader := ts.insertUser(t, "reader@example.com", "pa55word1234", true, "movies:read")
+	token := ts.newToken(t, reader, data.ScopeAuthentication)
+	movie := ts.insertMovie(t, "Black Panther", 2018, 134, "sci-fi", "action")
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Valid ID",
+			urlPath:    fmt.Sprintf("/v1/movies/%d", movie.ID),
+			wantStatus: http.StatusOK,
+			wantBody: `{"movie": {
+				"id": 1,
+				"title": "Black Panther",
+				"year": 2018,
+				"runtime": "134 mins",
+				"genres": ["sci-fi", "action"],
+				"version": 1
+			}}`,
+		},
+		{
+			name:       "Non-existent ID",
+			urlPath:    "/v1/movies/2",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error": "the requested resource could not be found"}`,
+		},
+		{
+			name:       "Negative ID",
+			urlPath:    "/v1/movies/-1",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error": "the requested resource could not be found"}`,
+		},
+		{
+			name:       "String ID",
+			urlPath:    "/v1/movies/foo",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error": "the requested resource could not be found"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodGet, tt.urlPath, token, nil)
+
+			assertStatus(t, res, tt.wantStatus)
+			assertJSON(t, res, tt.wantBody)
+		})
+	}
+}
+
+func TestUpdateMovie(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	writer := ts.insertUser(t, "writer@example.com", "pa55word1234", true, "movies:read", "movies:write")
+	token := ts.newToken(t, writer, data.ScopeAuthentication)
+	movie := ts.insertMovie(t, "Black Panther", 2018, 134, "sci-fi", "action")
+
+	t.Run("Partial update", func(t *testing.T) {
+		res := ts.request(t, http.MethodPatch, fmt.Sprintf("/v1/movies/%d", movie.ID), token, map[string]any{"year": 2017})
+
+		assertStatus(t, res, http.StatusOK)
+		assertJSON(t, res, `{"movie": {
+			"id": 1,
+			"title": "Black Panther",
+			"year": 2017,
+			"runtime": "134 mins",
+			"genres": ["sci-fi", "action"],
+			"version": 2
+		}}`)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		res := ts.request(t, http.MethodPatch, fmt.Sprintf("/v1/movies/%d", movie.ID), token, map[string]any{"genres": []string{}})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"genres": "must contain at least 1 genre"}}`)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		res := ts.request(t, http.MethodPatch, "/v1/movies/99", token, map[string]any{"year": 2017})
+
+		assertStatus(t, res, http.StatusNotFound)
+		assertJSON(t, res, `{"error": "the requested resource could not be found"}`)
+	})
+
+	t.Run("Edit conflict", func(t *testing.T) {
+		movies := app.models.Movies
+		app.models.Movies = racingMovieStore{MovieStore: movies}
+		t.Cleanup(func() { app.models.Movies = movies })
+
+		res := ts.request(t, http.MethodPatch, fmt.Sprintf("/v1/movies/%d", movie.ID), token, map[string]any{"year": 2018})
+
+		assertStatus(t, res, http.StatusConflict)
+		assertJSON(t, res, `{"error": "unable to update the record due to an edit conflict, please try again"}`)
+	})
+}
+
+func TestDeleteMovie(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	writer := ts.insertUser(t, "writer@example.com", "pa55word1234", true, "movies:read", "movies:write")
+	token := ts.newToken(t, writer, data.ScopeAuthentication)
+	movie := ts.insertMovie(t, "Black Panther", 2018, 134, "sci-fi", "action")
+
+	res := ts.request(t, http.MethodDelete, fmt.Sprintf("/v1/movies/%d", movie.ID), token, nil)
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"message": "movie successfully deleted"}`)
+
+	res = ts.request(t, http.MethodDelete, fmt.Sprintf("/v1/movies/%d", movie.ID), token, nil)
+	assertStatus(t, res, http.StatusNotFound)
+	assertJSON(t, res, `{"error": "the requested resource could not be found"}`)
+}
+
+func TestListMovies(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	reader := ts.insertUser(t, "reader@example.com", "pa55word1234", true, "movies:read")
+	token := ts.newToken(t, reader, data.ScopeAuthentication)
+
+	ts.insertMovie(t, "Moana", 2016, 107, "animation", "adventure")
+	ts.insertMovie(t, "Black Panther", 2018, 134, "sci-fi", "action", "adventure")
+	ts.insertMovie(t, "Deadpool", 2016, 108, "action", "comedy")
+	ts.insertMovie(t, "The Breakfast Club", 1986, 96, "drama")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int64
+		wantMeta   data.Metadata
+		wantBody   string
+	}{
+		{
+			name:       "Defaults",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{1, 2, 3, 4},
+			wantMeta:   data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 4},
+		},
+		{
+			name:       "Title search",
+			query:      "?title=the+club",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{4},
+			wantMeta:   data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+		},
+		{
+			name:       "Genre containment",
+			query:      "?genres=action,adventure",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{2},
+			wantMeta:   data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+		},
+		{
+			name:       "Sort descending with ID tiebreaker",
+			query:      "?sort=-year",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{2, 1, 3, 4},
+			wantMeta:   data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 4},
+		},
+		{
+			name:       "Pagination",
+			query:      "?sort=title&page=2&page_size=3",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{4},
+			wantMeta:   data.Metadata{CurrentPage: 2, PageSize: 3, FirstPage: 1, LastPage: 2, TotalRecords: 4},
+		},
+		{
+			name:       "Page out of range",
+			query:      "?page=5",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"metadata": {}, "movies": null}`,
+		},
+		{
+			name:       "Invalid filters",
+			query:      "?page=0&page_size=101&sort=rating",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody: `{"error": {
+				"page": "must be greater than zero",
+				"page_size": "must be a maximum of 100",
+				"sort": "invalid sort value"
+			}}`,
+		},
+		{
+			name:       "Non-integer page",
+			query:      "?page=abc",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `{"error": {"page": "must be an integer value"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodGet, "/v1/movies"+tt.query, token, nil)
+
+			assertStatus(t, res, tt.wantStatus)
+
+			if tt.wantBody != "" {
+				assertJSON(t, res, tt.wantBody)
+				return
+			}
+
+			var body struct {
+				Metadata data.Metadata `json:"metadata"`
+				Movies   []data.Movie  `json:"movies"`
+			}
+			decodeJSON(t, res, &body)
+
+			var ids []int64
+			for _, movie := range body.Movies {
+				ids = append(ids, movie.ID)
+			}
+
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("got movie IDs %v; want %v", ids, tt.wantIDs)
+			}
+			if body.Metadata != tt.wantMeta {
+				t.Errorf("got metadata %+v; want %+v", body.Metadata, tt.wantMeta)
+			}
+		})
+	}
+}
+
+func TestListMoviesByCursor(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	reader := ts.insertUser(t, "reader@example.com", "pa55word1234", true, "movies:read")
+	token := ts.newToken(t, reader, data.ScopeAuthentication)
+
+	ts.insertMovie(t, "Moana", 2016, 107, "animation", "adventure")
+	ts.insertMovie(t, "Black Panther", 2018, 134, "sci-fi", "action", "adventure")
+	ts.insertMovie(t, "Deadpool", 2016, 108, "action", "comedy")
+	ts.insertMovie(t, "The Breakfast Club", 1986, 96, "drama")
+	ts.insertMovie(t, "Up", 2009, 96, "animation")
+
+	type page struct {
+		Metadata data.Metadata `json:"metadata"`
+		Movies   []data.Movie  `json:"movies"`
+	}
+
+	getPage := func(t *testing.T, query string) page {
+		t.Helper()
+
+		res := ts.request(t, http.MethodGet, "/v1/movies?"+query, token, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		var p page
+		decodeJSON(t, res, &p)
+		return p
+	}
+
+	ids := func(p page) string {
+		var ids []int64
+		for _, movie := range p.Movies {
+			ids = append(ids, movie.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	tests := []struct {
+		sort      string
+		wantPages []string
+	}{
+		{sort: "id", wantPages: []string{"[1 2]", "[3 4]", "[5]"}},
+		{sort: "-id", wantPages: []string{"[5 4]", "[3 2]", "[1]"}},
+		{sort: "-year", wantPages: []string{"[2 1]", "[3 5]", "[4]"}},
+		{sort: "runtime", wantPages: []string{"[4 5]", "[1 3]", "[2]"}},
+		{sort: "title", wantPages: []string{"[2 3]", "[1 4]", "[5]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			// Walk forwards through all the pages using the next cursors...
+			var pages []page
+			p := getPage(t, "page_size=2&cursor=&sort="+tt.sort)
+			for {
+				pages = append(pages, p)
+				if p.Metadata.NextCursor == "" {
+					break
+				}
+				p = getPage(t, "page_size=2&sort="+tt.sort+"&cursor="+p.Metadata.NextCursor)
+			}
+
+			if len(pages) != len(tt.wantPages) {
+				t.Fatalf("got %d pages; want %d", len(pages), len(tt.wantPages))
+			}
+			for i := range pages {
+				if got := ids(pages[i]); got != tt.wantPages[i] {
+					t.Errorf("page %d: got movie IDs %s; want %s", i+1, got, tt.wantPages[i])
+				}
+				if pages[i].Metadata.TotalRecords != 0 || pages[i].Metadata.PageSize != 2 {
+					t.Errorf("page %d: got metadata %+v; want only page_size and cursors", i+1, pages[i].Metadata)
+				}
+			}
+			if pages[0].Metadata.PrevCursor != "" {
+				t.Error("first page should not have a prev_cursor")
+			}
+
+			// ...and then backwards again using the prev cursors.
+			for i := len(pages) - 1; i > 0; i-- {
+				p := getPage(t, "page_size=2&sort="+tt.sort+"&cursor="+pages[i].Metadata.PrevCursor)
+				if got := ids(p); got != tt.wantPages[i-1] {
+					t.Errorf("prev of page %d: got movie IDs %s; want %s", i+1, got, tt.wantPages[i-1])
+				}
+			}
+		})
+	}
+
+	t.Run("Movies inserted concurrently", func(t *testing.T) {
+		first := getPage(t, "page_size=2&cursor=")
+
+		// Inserting a movie which sorts before the cursor must not shift the next page.
+		ts.insertMovie(t, "Zootopia", 2016, 108, "animation")
+
+		next := getPage(t, "page_size=2&cursor="+first.Metadata.NextCursor)
+		if got := ids(next); got != "[3 4]" {
+			t.Errorf("got movie IDs %s; want [3 4]", got)
+		}
+	})
+
+	invalid := []struct {
+		name  string
+		query string
+	}{
+		{"Tampered cursor", "cursor=eyJzIjoiaWQiLCJ2IjoiMiIsImkiOjJ9.AAAA"},
+		{"Garbage cursor", "cursor=not-a-cursor"},
+		{"Cursor with page", "cursor=&page=2"},
+		{"Cursor for another sort", "sort=title&cursor=" + getPage(t, "page_size=1&cursor=").Metadata.NextCursor},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodGet, "/v1/movies?"+tt.query, token, nil)
+
+			assertStatus(t, res, http.StatusUnprocessableEntity)
+
+			var body struct {
+				Error map[string]string `json:"error"`
+			}
+			decodeJSON(t, res, &body)
+			if body.Error["cursor"] == "" {
+				t.Errorf("got errors %v; want a cursor error", body.Error)
+			}
+		})
+	}
+}
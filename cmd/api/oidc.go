@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/mailer"
+	"github.com/rynhndrcksn/greenlight/internal/oidc"
+	"github.com/rynhndrcksn/greenlight/internal/validator"
+)
+
+// oidcLoginTTL is how long a user has to log in with the identity provider after
+// starting an OpenID Connect login.
+const oidcLoginTTL = 10 * time.Minute
+
+// errUnverifiedEmail and errMissingEmail are returned by userForIdentity when an
+// identity isn't linked to a user yet, and the provider either hasn't verified its
+// email address or didn't send a valid one.
+var (
+	errUnverifiedEmail = errors.New("unverified email address")
+	errMissingEmail    = errors.New("missing email address")
+)
+
+// errDeactivated is returned by userForIdentity when the identity belongs to, or would
+// be linked to, a user who has been deactivated by an admin.
+var errDeactivated = errors.New("deactivated user")
+
+// createOIDCAuthorizationHandler starts logging in with the OpenID Connect provider. It
+// returns the URL of the provider's login page for the client to send the user to. The
+// provider sends them back to the configured redirect URL with a code and state, which
+// the client exchanges for the session tokens at POST /v1/tokens/oidc.
+func (app *application) createOIDCAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	login := &data.OIDCLogin{Expiry: time.Now().Add(oidcLoginTTL)}
+
+	for _, s := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		var err error
+		*s, err = oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.OIDCLogins.Insert(r.Context(), login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOIDCAuthenticationTokenHandler finishes logging in with the OpenID Connect
+// provider. It exchanges the code for an ID token, finds (or creates) the user the
+// identity in it belongs to, and issues the session tokens just like a password login.
+func (app *application) createOIDCAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Each login can only be finished once, so the state can't be replayed.
+	login, err := app.models.OIDCLogins.Take(r.Context(), input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), input.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidGrant):
+			v.AddError("code", "invalid or expired authorization code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.userForIdentity(r, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			app.unverifiedEmailResponse(w, r)
+		case errors.Is(err, errMissingEmail):
+			app.missingEmailResponse(w, r)
+		case errors.Is(err, errDeactivated):
+			app.deactivatedAccountResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The identity provider stands in for the password, so users with two-factor
+	// authentication enabled still need to enter a code.
+	mfaEnabled, err := app.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if mfaEnabled {
+		app.mfaRequiredResponse(w, r, user.ID)
+		return
+	}
+
+	env, err := app.createSessionTokens(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userForIdentity returns the user an identity from the OpenID Connect provider belongs
+// to. If it isn't linked to a user yet, the provider must have verified its email
+// address, otherwise anyone could sign up with the provider using someone else's
+// address and take over their account, or claim the address before they register. The
+// identity is then linked to the user with the same email address, who is activated
+// (with claimUnactivatedUser) if they weren't already, as the provider has done the
+// same job as the activation email. If there's no user with the address, a new one is
+// created. Users who have been deactivated by an admin can't log in this way, and
+// aren't activated again.
+func (app *application) userForIdentity(r *http.Request, claims *oidc.Claims) (*data.User, error) {
+	ctx := r.Context()
+
+	user, err := app.models.Users.GetForIdentity(ctx, app.oidc.Issuer(), claims.Subject)
+	switch {
+	case err == nil && user.Deactivated():
+		return nil, errDeactivated
+	case err == nil || !errors.Is(err, data.ErrRecordNotFound):
+		return user, err
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, claims.Email); !v.Valid() {
+		return nil, errMissingEmail
+	}
+
+	if !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err = app.models.Users.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil && user.Deactivated():
+		return nil, errDeactivated
+	case err == nil:
+		err = app.models.Transaction(ctx, func(tx data.Models) error {
+			err := app.linkIdentity(ctx, tx, user, claims)
+			if err != nil || user.Activated {
+				return err
+			}
+
+			return app.claimUnactivatedUser(ctx, tx, user)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	return app.createUserForIdentity(r, claims)
+}
+
+// claimUnactivatedUser activates a user who has never activated their account, when
+// they first log in with the identity provider. Nothing proves that whoever registered
+// the account owns the email address, so it could have been registered by someone else
+// waiting for the real owner to log in. The user gets a random password, and all their
+// tokens and API keys are deleted, so nothing set up before now still works.
+func (app *application) claimUnactivatedUser(ctx context.Context, tx data.Models, user *data.User) error {
+	err := user.Password.SetRandom()
+	if err != nil {
+		return err
+	}
+
+	user.Activated = true
+
+	err = tx.Users.Update(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	scopes := []string{
+		data.ScopeActivation,
+		data.ScopeAuthentication,
+		data.ScopeRefresh,
+		data.ScopePasswordReset,
+		data.ScopeMFAPending,
+		data.ScopeEmailChange,
+	}
+	for _, scope := range scopes {
+		err = tx.Tokens.DeleteAllForUser(ctx, scope, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	keys, err := tx.APIKeys.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = tx.APIKeys.Delete(ctx, user.ID, key.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createUserForIdentity creates a new user for an identity from the OpenID Connect
+// provider with a verified email address, giving them the viewer role like registered
+// users. They're activated straight away, and get a random password, which they can
+// replace with a password reset if they want to log in with a password too.
+func (app *application) createUserForIdentity(r *http.Request, claims *oidc.Claims) (*data.User, error) {
+	ctx := r.Context()
+
+	user := &data.User{
+		Name:            claims.Name,
+		Email:           claims.Email,
+		Activated:       true,
+		PreferredLocale: mailer.MatchLocale(app.readAcceptLanguage(r)...),
+	}
+	if user.Name == "" {
+		user.Name = claims.Email
+	}
+
+	err := user.Password.SetRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	// The email address has already been checked, so only an overly long name from the
+	// provider could fail here, and there's nothing the client can do about that.
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return nil, errors.New("identity provider returned an invalid name or email address")
+	}
+
+	err = app.models.Transaction(ctx, func(tx data.Models) error {
+		err := tx.Users.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Roles.AddForUser(ctx, user.ID, data.RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		return app.linkIdentity(ctx, tx, user, claims)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// linkIdentity links an identity from the OpenID Connect provider to the user.
+func (app *application) linkIdentity(ctx context.Context, tx data.Models, user *data.User, claims *oidc.Claims) error {
+	return tx.Identities.Insert(ctx, &data.Identity{
+		Issuer:  app.oidc.Issuer(),
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/oidc"
+	"github.com/rynhndrcksn/greenlight/internal/oidc/oidctest"
+)
+
+// newOIDCTestServer returns a test server which users can log in to with a stand-in
+// OpenID Connect provider.
+func newOIDCTestServer(t *testing.T) (*testServer, *oidctest.Provider) {
+	t.Helper()
+
+	provider := oidctest.NewProvider(t)
+
+	app := newTestApplication(t)
+	app.oidc = oidc.New(provider.Config("https://greenlight.example.com/oidc/callback"))
+
+	return newTestServer(t, app), provider
+}
+
+// oidcLogin logs in to the stand-in provider as the identity, and returns the response
+// to exchanging the code for session tokens.
+func oidcLogin(t *testing.T, ts *testServer, provider *oidctest.Provider, identity oidctest.Identity) testResponse {
+	t.Helper()
+
+	res := ts.request(t, http.MethodPost, "/v1/oidc/authorize", "", nil)
+	assertStatus(t, res, http.StatusOK)
+
+	var body struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	decodeJSON(t, res, &body)
+
+	code, state, err := provider.Authorize(body.AuthorizationURL, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ts.request(t, http.MethodPost, "/v1/tokens/oidc", "", map[string]any{"code": code, "state": state})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	t.Parallel()
+
+	alice := oidctest.Identity{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice Smith"}
+
+	t.Run("New user", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusCreated)
+
+		var tokens sessionTokens
+		decodeJSON(t, res, &tokens)
+
+		// The user is created already activated, with the viewer role.
+		res = ts.request(t, http.MethodGet, "/v1/movies", tokens.AuthenticationToken.Token, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		user, err := ts.app.models.Users.GetByEmail(context.Background(), alice.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Name != alice.Name || !user.Activated {
+			t.Errorf("got user %q (activated %t); want %q (activated true)", user.Name, user.Activated, alice.Name)
+		}
+
+		// Logging in again finds the same user through the linked identity, even after
+		// the email address changes at the provider.
+		alice := alice
+		alice.Email = "alice.smith@example.com"
+
+		res = oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusCreated)
+
+		_, err = ts.app.models.Users.GetByEmail(context.Background(), alice.Email)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("got error %v; want %v", err, data.ErrRecordNotFound)
+		}
+	})
+
+	t.Run("Existing user with verified email", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+		user := ts.insertUser(t, alice.Email, "pa55word1234", true)
+
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusCreated)
+
+		got, err := ts.app.models.Users.GetForIdentity(context.Background(), provider.URL, alice.Subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID {
+			t.Errorf("got user %d; want %d", got.ID, user.ID)
+		}
+
+		// The password still works.
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": alice.Email, "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusCreated)
+	})
+
+	t.Run("Unactivated user with verified email", func(t *testing.T) {
+		t.Parallel()
+
+		// Someone else could have registered the account with Alice's email address,
+		// and be waiting for her to log in with the provider.
+		ts, provider := newOIDCTestServer(t)
+		user := ts.insertUser(t, alice.Email, "pa55word1234", false)
+		sessionToken := ts.newToken(t, user, data.ScopeAuthentication)
+		refreshToken := ts.newToken(t, user, data.ScopeRefresh)
+		activationToken := ts.newToken(t, user, data.ScopeActivation)
+
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusCreated)
+
+		got, err := ts.app.models.Users.GetForIdentity(context.Background(), provider.URL, alice.Subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || !got.Activated {
+			t.Errorf("got user %d (activated %t); want %d (activated true)", got.ID, got.Activated, user.ID)
+		}
+
+		// The password they chose and the tokens issued before the link no longer work.
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": alice.Email, "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = ts.request(t, http.MethodGet, "/v1/users/me", sessionToken, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": refreshToken})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+
+		res = ts.request(t, http.MethodPut, "/v1/users/activated", "", map[string]any{"token": activationToken})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("Existing user with unverified email", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+		ts.insertUser(t, alice.Email, "pa55word1234", true)
+
+		alice := alice
+		alice.EmailVerified = false
+
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusForbidden)
+		assertJSON(t, res, `{"error": "the identity provider hasn't verified your email address"}`)
+	})
+
+	t.Run("Deactivated user", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+		user := ts.insertUser(t, alice.Email, "pa55word1234", false)
+
+		deactivatedAt := time.Now()
+		user.DeactivatedAt = &deactivatedAt
+
+		err := ts.app.models.Users.Update(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Logging in with a verified email address doesn't activate the user again...
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusForbidden)
+		assertJSON(t, res, `{"error": "your user account has been deactivated"}`)
+
+		got, err := ts.app.models.Users.Get(context.Background(), user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Activated {
+			t.Error("got an activated user; want them still deactivated")
+		}
+
+		// ...and the identity isn't linked to them.
+		_, err = ts.app.models.Users.GetForIdentity(context.Background(), provider.URL, alice.Subject)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("got error %v; want %v", err, data.ErrRecordNotFound)
+		}
+	})
+
+	t.Run("New user with unverified email", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+
+		alice := alice
+		alice.EmailVerified = false
+
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusForbidden)
+		assertJSON(t, res, `{"error": "the identity provider hasn't verified your email address"}`)
+
+		// No user is created, so the owner of the address can still register with it.
+		_, err := ts.app.models.Users.GetByEmail(context.Background(), alice.Email)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("got error %v; want %v", err, data.ErrRecordNotFound)
+		}
+	})
+
+	t.Run("Missing email", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+
+		alice := alice
+		alice.Email = ""
+
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusForbidden)
+		assertJSON(t, res, `{"error": "the identity provider didn't share a valid email address"}`)
+	})
+
+	t.Run("MFA required", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+		user := ts.insertUser(t, alice.Email, "pa55word1234", true)
+		enableMFA(t, ts, ts.newToken(t, user, data.ScopeAuthentication))
+
+		res := oidcLogin(t, ts, provider, alice)
+		assertStatus(t, res, http.StatusAccepted)
+
+		var body struct {
+			MFARequired bool `json:"mfa_required"`
+		}
+		decodeJSON(t, res, &body)
+
+		if !body.MFARequired {
+			t.Error("got mfa_required false; want true")
+		}
+	})
+
+	t.Run("Invalid state and code", func(t *testing.T) {
+		t.Parallel()
+
+		ts, provider := newOIDCTestServer(t)
+
+		res := ts.request(t, http.MethodPost, "/v1/oidc/authorize", "", nil)
+
+		var body struct {
+			AuthorizationURL string `json:"authorization_url"`
+		}
+		decodeJSON(t, res, &body)
+
+		code, state, err := provider.Authorize(body.AuthorizationURL, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/oidc", "", map[string]any{"code": code, "state": "unknown"})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"state": "invalid or expired state"}}`)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/oidc", "", map[string]any{"code": "unknown", "state": state})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"code": "invalid or expired authorization code"}}`)
+
+		// The state was used up by the failed attempt.
+		res = ts.request(t, http.MethodPost, "/v1/tokens/oidc", "", map[string]any{"code": code, "state": state})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"state": "invalid or expired state"}}`)
+	})
+
+	t.Run("Not configured", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+
+		res := ts.request(t, http.MethodPost, "/v1/oidc/authorize", "", nil)
+		assertStatus(t, res, http.StatusNotFound)
+	})
+}
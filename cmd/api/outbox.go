@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+const (
+	// outboxBatchSize is the number of emails the worker claims at a time.
+	outboxBatchSize = 10
+
+	// outboxLease is how long a claimed email is left alone before another worker can
+	// pick it up. It needs to be comfortably longer than sending a batch takes.
+	outboxLease = time.Minute
+
+	// outboxBaseDelay is how long the worker waits before retrying an email the first
+	// time it fails. The delay doubles after each failure, up to outboxMaxDelay.
+	outboxBaseDelay = 10 * time.Second
+	outboxMaxDelay  = time.Hour
+
+	// outboxPruneInterval is how often the worker deletes the emails which finished
+	// longer ago than the retention period.
+	outboxPruneInterval = time.Hour
+)
+
+// outboxBackoff returns how long to wait before the next attempt at sending an email
+// which has failed the given number of times.
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, outboxMaxDelay)
+}
+
+// wakeOutbox tells the outbox worker that there are new emails to send, rather than
+// leaving them until it next polls. It never blocks, and does nothing if the worker
+// isn't running.
+func (app *application) wakeOutbox() {
+	select {
+	case app.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutbox sends the emails in the outbox until ctx is cancelled, checking for new
+// ones every poll interval or whenever it's woken up. Every prune interval it also
+// deletes the emails which finished longer ago than the retention period.
+func (app *application) runOutbox(ctx context.Context) {
+	ticker := time.NewTicker(app.config.outbox.pollInterval)
+	defer ticker.Stop()
+
+	var lastPruned time.Time
+
+	for {
+		err := app.processOutbox(ctx)
+		if err != nil && ctx.Err() == nil {
+			app.logger.Error("processing email outbox", slog.String("error", err.Error()))
+		}
+
+		if time.Since(lastPruned) >= outboxPruneInterval {
+			lastPruned = time.Now()
+
+			err = app.pruneOutbox(ctx)
+			if err != nil && ctx.Err() == nil {
+				app.logger.Error("pruning email outbox", slog.String("error", err.Error()))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-app.outboxWake:
+		}
+	}
+}
+
+// processOutbox sends every email in the outbox which is due. Emails which fail are
+// retried with exponential backoff, until they've failed the maximum number of times
+// and are marked as dead.
+func (app *application) processOutbox(ctx context.Context) error {
+	for {
+		emails, err := app.models.Outbox.Claim(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			return err
+		}
+
+		for _, email := range emails {
+			// Stop between emails when shutting down. Any we've claimed but not sent
+			// are picked up again once their lease runs out.
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			app.sendEmail(ctx, email)
+		}
+
+		if len(emails) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// pruneOutbox deletes the sent and dead emails which were queued longer ago than the
+// retention period.
+func (app *application) pruneOutbox(ctx context.Context) error {
+	deleted, err := app.models.Outbox.Prune(ctx, time.Now().Add(-app.config.outbox.retention))
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("pruned email outbox", slog.Int64("deleted", deleted))
+	}
+
+	return nil
+}
+
+// sendEmail makes one attempt at sending an email from the outbox and records the result.
+func (app *application) sendEmail(ctx context.Context, email *data.Email) {
+	email.Attempts++
+
+	err := app.mailer.Send(email.Recipient, email.Locale, email.Template, email.Data)
+	switch {
+	case err == nil:
+		now := time.Now()
+		email.Status = data.EmailSent
+		email.SentAt = &now
+		email.LastError = ""
+		app.prometheus.emails.With("sent").Inc()
+	case email.Attempts >= app.config.outbox.maxAttempts:
+		email.Status = data.EmailDead
+		email.LastError = err.Error()
+		app.prometheus.emails.With("dead").Inc()
+		app.logger.Error("giving up sending email", slog.Int64("id", email.ID), slog.Int("attempts", email.Attempts), slog.String("error", err.Error()))
+	default:
+		email.NextAttemptAt = time.Now().Add(outboxBackoff(email.Attempts))
+		email.LastError = err.Error()
+		app.prometheus.emails.With("retry").Inc()
+		app.logger.Warn("sending email failed", slog.Int64("id", email.ID), slog.Int("attempts", email.Attempts), slog.String("error", err.Error()))
+	}
+
+	// Always record the attempt, even if we're shutting down, otherwise an email which
+	// was sent would be sent again once its lease runs out.
+	err = app.models.Outbox.Update(context.WithoutCancel(ctx), email)
+	if err != nil {
+		app.logger.Error("updating outbox email", slog.Int64("id", email.ID), slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/mailer"
+)
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	sender := app.mailer.(*mailer.MemorySender)
+
+	assertCounts := func(t *testing.T, pending, sent, dead int) {
+		t.Helper()
+
+		counts, err := app.models.Outbox.CountByStatus(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if counts[data.EmailPending] != pending || counts[data.EmailSent] != sent || counts[data.EmailDead] != dead {
+			t.Errorf("got counts %v; want %d pending, %d sent and %d dead", counts, pending, sent, dead)
+		}
+	}
+
+	register := func(t *testing.T, email string) testResponse {
+		t.Helper()
+
+		body := map[string]any{"name": "Test User", "email": email, "password": "pa55word1234"}
+		return ts.request(t, http.MethodPost, "/v1/users", "", body)
+	}
+
+	t.Run("Sent", func(t *testing.T) {
+		res := register(t, "alice@example.com")
+		assertStatus(t, res, http.StatusAccepted)
+
+		if emails := sender.Sent(); len(emails) != 1 {
+			t.Fatalf("got %d emails; want 1", len(emails))
+		}
+		assertCounts(t, 0, 1, 0)
+	})
+
+	t.Run("Not queued when registering fails", func(t *testing.T) {
+		res := register(t, "alice@example.com")
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+
+		assertCounts(t, 0, 1, 0)
+	})
+
+	t.Run("Retried after failing", func(t *testing.T) {
+		sender.Fail(errors.New("connection refused"))
+		defer sender.Fail(nil)
+
+		res := register(t, "bob@example.com")
+		assertStatus(t, res, http.StatusAccepted)
+
+		// The email failed once, and it isn't due to be retried yet.
+		assertCounts(t, 1, 1, 0)
+
+		emails, err := app.models.Outbox.Claim(context.Background(), 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(emails) != 0 {
+			t.Errorf("claimed %d emails before the backoff had passed; want 0", len(emails))
+		}
+	})
+
+	t.Run("Dead after too many attempts", func(t *testing.T) {
+		sender.Fail(errors.New("connection refused"))
+		defer sender.Fail(nil)
+
+		email := &data.Email{Recipient: "carol@example.com", Template: "user_welcome.tmpl"}
+		err := app.models.Outbox.Enqueue(context.Background(), email)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Make the next attempt the last one.
+		email.Attempts = app.config.outbox.maxAttempts - 1
+		app.sendEmail(context.Background(), email)
+
+		if email.Status != data.EmailDead || email.LastError != "connection refused" {
+			t.Errorf("got status %q and last error %q; want %q and %q", email.Status, email.LastError, data.EmailDead, "connection refused")
+		}
+		assertCounts(t, 1, 1, 1)
+	})
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
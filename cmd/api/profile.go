@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/mailer"
+	"github.com/rynhndrcksn/greenlight/internal/validator"
+)
+
+// emailChangeTTL is how long a user has to confirm a new email address.
+const emailChangeTTL = 24 * time.Hour
+
+// profile is how the user making the request is shown their own account. Like the admin
+// representation it includes the version number, which they need to send back when
+// updating it, along with the new email address they're waiting to confirm (if any).
+type profile struct {
+	*data.User
+	Version      int    `json:"version"`
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+// newProfile looks up the user's pending email change and wraps them for sending in a
+// profile response.
+func (app *application) newProfile(r *http.Request, user *data.User) (profile, error) {
+	pendingEmail, err := app.models.EmailChanges.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return profile{}, err
+	}
+
+	return profile{User: user, Version: user.Version, PendingEmail: pendingEmail}, nil
+}
+
+// showProfileHandler shows the user making the request their own account.
+func (app *application) showProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.loadUser(r)
+	if err != nil {
+		app.loadUserErrorResponse(w, r, err)
+		return
+	}
+
+	p, err := app.newProfile(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": p}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateProfileHandler lets the user making the request change their name, locale,
+// email address and password. The client must send the version of the user they last
+// saw, and we return a 409 Conflict if the user has changed since.
+//
+// Changing the email address or password needs the current password. A new email
+// address isn't used until the user confirms it with the token we send there, at
+// PUT /v1/users/me/email. Changing the password logs the user out everywhere, so the
+// response includes a new pair of session tokens for the client to carry on with.
+func (app *application) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword *string `json:"current_password"`
+		Locale          *string `json:"locale"`
+		Version         *int    `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.loadUser(r)
+	if err != nil {
+		app.loadUserErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Version != nil, "version", "must be provided")
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Locale != nil {
+		user.PreferredLocale = *input.Locale
+		v.Check(slices.Contains(mailer.Locales(), user.PreferredLocale), "locale", "must be a supported locale")
+	}
+
+	// The new email address is validated here, but the user keeps their current one
+	// until they confirm the change.
+	changeEmail := input.Email != nil && *input.Email != user.Email
+	if changeEmail {
+		data.ValidateEmail(v, *input.Email)
+	}
+
+	changePassword := input.Password != nil
+	if changePassword {
+		data.ValidatePasswordPlaintext(v, *input.Password)
+	}
+
+	if changeEmail || changePassword {
+		v.Check(input.CurrentPassword != nil && *input.CurrentPassword != "", "current_password", "must be provided")
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Wrong passwords count as failed logins, so a stolen session can't be used to guess
+	// the password without being locked out.
+	if changeEmail || changePassword {
+		retryAfter, err := app.loginRetryAfter(r, user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if retryAfter > 0 {
+			app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+			return
+		}
+
+		match, err := user.Password.Matches(*input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !match {
+			err = app.recordLoginFailure(r, user.Email, user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			v.AddError("current_password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	if changePassword {
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Check up front that nobody else has the new email address, so the user finds out
+	// now rather than when they try to confirm it. Update() still checks again then.
+	if changeEmail {
+		_, err = app.models.Users.GetByEmail(r.Context(), *input.Email)
+		switch {
+		case err == nil:
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Update() only saves the user if the stored version still matches, so using the
+	// client's version here gives us optimistic locking across requests. The new email
+	// address is recorded along with the user, replacing any earlier change and its
+	// tokens, and the confirmation email is queued in the same transaction.
+	user.Version = *input.Version
+
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil || !changeEmail {
+			return err
+		}
+
+		err = tx.EmailChanges.Set(r.Context(), user.ID, *input.Email)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(r.Context(), user.ID, emailChangeTTL, data.ScopeEmailChange)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(r.Context(), &data.Email{
+			Recipient: *input.Email,
+			Locale:    user.PreferredLocale,
+			Template:  "token_email_change.tmpl",
+			Data:      map[string]any{"emailChangeToken": token.Plaintext},
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if changeEmail {
+		app.wakeOutbox()
+	}
+
+	p, err := app.newProfile(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"user": p}
+
+	// Log the user out everywhere in case someone else knew the old password, then
+	// start a new session for the client making the request.
+	if changePassword {
+		err = app.revokeSessions(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.resetLoginFailures(r.Context(), user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		tokens, err := app.createSessionTokens(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for key, value := range tokens {
+			env[key] = value
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler switches a user to the new email address they asked to
+// change to, if the correct email-change token is sent to this endpoint. Like the
+// activation token, the token proves the user can receive email at the address, so the
+// request doesn't need to be authenticated. A notice is sent to the old address in case
+// someone else made the change.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	newEmail, err := app.models.EmailChanges.Get(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = newEmail
+
+	// Switch the email address and clear out the change, along with any password reset
+	// tokens which were sent to the old address.
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.EmailChanges.Delete(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, scope := range []string{data.ScopeEmailChange, data.ScopePasswordReset} {
+			err = tx.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Outbox.Enqueue(r.Context(), &data.Email{
+			Recipient: oldEmail,
+			Locale:    user.PreferredLocale,
+			Template:  "email_changed.tmpl",
+			Data:      map[string]any{"newEmail": user.Email},
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wakeOutbox()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteProfileHandler lets the user making the request delete their own account,
+// along with their tokens, roles, permissions and everything else tied to it. It needs
+// the user's password, so a stolen session isn't enough to delete the account, and wrong
+// passwords count as failed logins.
+func (app *application) deleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.loadUser(r)
+	if err != nil {
+		app.loadUserErrorResponse(w, r, err)
+		return
+	}
+
+	retryAfter, err := app.loginRetryAfter(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Delete(r.Context(), user.ID, user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Show and update", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodGet, "/v1/users/me", token, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		var got struct {
+			User struct {
+				Name    string `json:"name"`
+				Email   string `json:"email"`
+				Locale  string `json:"preferred_locale"`
+				Version int    `json:"version"`
+			} `json:"user"`
+		}
+		decodeJSON(t, res, &got)
+
+		if got.User.Email != "alice@example.com" || got.User.Version != 1 {
+			t.Fatalf("got user %+v; want alice@example.com at version 1", got.User)
+		}
+
+		res = ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"name": "Alice", "locale": "es", "version": 1})
+		assertStatus(t, res, http.StatusOK)
+		decodeJSON(t, res, &got)
+
+		if got.User.Name != "Alice" || got.User.Locale != "es" || got.User.Version != 2 {
+			t.Errorf("got user %+v; want Alice in es at version 2", got.User)
+		}
+
+		// The old version is stale now.
+		res = ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"name": "Bob", "version": 1})
+		assertStatus(t, res, http.StatusConflict)
+
+		res = ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"name": "Bob"})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"version": "must be provided"}}`)
+
+		res = ts.request(t, http.MethodGet, "/v1/users/me", "", nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+	})
+
+	t.Run("Change password", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"password": "n3wpa55word", "version": 1})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"current_password": "must be provided"}}`)
+
+		res = ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"password": "n3wpa55word", "current_password": "wrong-password", "version": 1})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"current_password": "is incorrect"}}`)
+
+		res = ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"password": "n3wpa55word", "current_password": "pa55word1234", "version": 1})
+		assertStatus(t, res, http.StatusOK)
+
+		var tokens sessionTokens
+		decodeJSON(t, res, &tokens)
+
+		// The other sessions are logged out, but the new one works.
+		res = ts.request(t, http.MethodGet, "/v1/users/me", token, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = ts.request(t, http.MethodGet, "/v1/users/me", tokens.AuthenticationToken.Token, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "n3wpa55word"})
+		assertStatus(t, res, http.StatusCreated)
+	})
+
+	t.Run("Change email", func(t *testing.T) {
+		t.Parallel()
+
+		app := newTestApplication(t)
+		ts := newTestServer(t, app)
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		ts.insertUser(t, "bob@example.com", "pa55word1234", true)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"email": "bob@example.com", "current_password": "pa55word1234", "version": 1})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"email": "a user with this email address already exists"}}`)
+
+		res = ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"email": "alice.smith@example.com", "current_password": "pa55word1234", "version": 1})
+		assertStatus(t, res, http.StatusOK)
+
+		var got struct {
+			User struct {
+				Email        string `json:"email"`
+				PendingEmail string `json:"pending_email"`
+			} `json:"user"`
+		}
+		decodeJSON(t, res, &got)
+
+		if got.User.Email != "alice@example.com" || got.User.PendingEmail != "alice.smith@example.com" {
+			t.Errorf("got user %+v; want alice@example.com pending alice.smith@example.com", got.User)
+		}
+
+		// The confirmation token is sent to the new address.
+		emails := sentEmails(app)
+		if len(emails) != 1 {
+			t.Fatalf("got %d emails; want 1", len(emails))
+		}
+		if emails[0].Recipient != "alice.smith@example.com" || emails[0].Template != "token_email_change.tmpl" {
+			t.Fatalf("got email %q to %q; want %q to %q", emails[0].Template, emails[0].Recipient, "token_email_change.tmpl", "alice.smith@example.com")
+		}
+
+		emailToken, _ := emailData(emails[0], "emailChangeToken").(string)
+
+		res = ts.request(t, http.MethodPut, "/v1/users/me/email", "", map[string]any{"token": emailToken})
+		assertStatus(t, res, http.StatusOK)
+
+		// A notice is sent to the old address.
+		emails = sentEmails(app)
+		if last := emails[len(emails)-1]; last.Recipient != "alice@example.com" || last.Template != "email_changed.tmpl" {
+			t.Errorf("got email %q to %q; want %q to %q", last.Template, last.Recipient, "email_changed.tmpl", "alice@example.com")
+		}
+
+		// The token can only be used once.
+		res = ts.request(t, http.MethodPut, "/v1/users/me/email", "", map[string]any{"token": emailToken})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"token": "invalid or expired email change token"}}`)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice.smith@example.com", "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusCreated)
+	})
+
+	t.Run("Delete account", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newTestApplication(t))
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodDelete, "/v1/users/me", token, map[string]any{"password": "wrong-password"})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"password": "is incorrect"}}`)
+
+		res = ts.request(t, http.MethodDelete, "/v1/users/me", token, map[string]any{"password": "pa55word1234"})
+		assertStatus(t, res, http.StatusOK)
+		assertJSON(t, res, `{"message": "your account has been deleted"}`)
+
+		res = ts.request(t, http.MethodGet, "/v1/users/me", token, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusUnauthorized)
+	})
+	t.Run("Wrong passwords count as failed logins", func(t *testing.T) {
+		t.Parallel()
+
+		app := newTestApplication(t)
+		app.config.login.maxFailures = 2
+		ts := newTestServer(t, app)
+
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		token := ts.newToken(t, user, data.ScopeAuthentication)
+
+		res := ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"password": "n3wpa55word", "current_password": "wrong-password", "version": 1})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+
+		res = ts.request(t, http.MethodDelete, "/v1/users/me", token, map[string]any{"password": "wrong-password"})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+
+		// The account is locked out, even with the right password.
+		res = ts.request(t, http.MethodPatch, "/v1/users/me", token, map[string]any{"password": "n3wpa55word", "current_password": "pa55word1234", "version": 1})
+		assertStatus(t, res, http.StatusTooManyRequests)
+
+		res = ts.request(t, http.MethodDelete, "/v1/users/me", token, map[string]any{"password": "pa55word1234"})
+		assertStatus(t, res, http.StatusTooManyRequests)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusTooManyRequests)
+	})
+}
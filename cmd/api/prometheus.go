@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"runtime"
+
+	"github.com/rynhndrcksn/greenlight/internal/metrics"
+)
+
+// prometheusMetrics holds the metrics served to Prometheus at /metrics.
+type prometheusMetrics struct {
+	registry        *metrics.Registry
+	requests        metrics.CounterVec
+	requestDuration metrics.HistogramVec
+	rateLimited     metrics.CounterVec
+	emails          metrics.CounterVec
+}
+
+// newPrometheusMetrics returns a new set of metrics, including the build information.
+func newPrometheusMetrics() *prometheusMetrics {
+	registry := metrics.NewRegistry()
+
+	registry.NewGaugeVec("greenlight_build_info", "Build information about the running server, always 1.", "version", "goversion").
+		With(version, runtime.Version()).
+		Set(1)
+
+	m := &prometheusMetrics{
+		registry:        registry,
+		requests:        registry.NewCounterVec("greenlight_http_requests_total", "HTTP requests handled, by route and response status.", "method", "route", "status"),
+		requestDuration: registry.NewHistogramVec("greenlight_http_request_duration_seconds", "Time taken to handle HTTP requests, by route.", metrics.DefaultBuckets, "method", "route"),
+		rateLimited:     registry.NewCounterVec("greenlight_rate_limit_rejections_total", "Requests rejected by the rate limiter."),
+		emails:          registry.NewCounterVec("greenlight_email_send_attempts_total", "Attempts at sending emails from the outbox, by outcome (sent, retry or dead).", "outcome"),
+	}
+
+	// Create the series we know about up front, so they're reported as zero rather
+	// than missing until something happens.
+	m.rateLimited.With()
+	for _, outcome := range []string{"sent", "retry", "dead"} {
+		m.emails.With(outcome)
+	}
+
+	return m
+}
+
+// registerDBStats adds metrics for the database connection pool.
+func (m *prometheusMetrics) registerDBStats(db *sql.DB) {
+	m.registry.NewGaugeFunc("greenlight_db_open_connections", "Established connections to the database, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	m.registry.NewGaugeFunc("greenlight_db_in_use_connections", "Database connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	m.registry.NewGaugeFunc("greenlight_db_idle_connections", "Idle database connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	m.registry.NewGaugeFunc("greenlight_db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	m.registry.NewCounterFunc("greenlight_db_wait_count_total", "Times a query had to wait for a database connection.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	m.registry.NewCounterFunc("greenlight_db_wait_duration_seconds_total", "Time spent waiting for database connections.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+}
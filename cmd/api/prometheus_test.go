@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	app.config.ops.username = "prometheus"
+	app.config.ops.password = "s3cret"
+	ts := newTestServer(t, app)
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	token := ts.newToken(t, user, data.ScopeAuthentication)
+	movie := ts.insertMovie(t, "Moana", 2016, 107, "animation")
+
+	ts.request(t, http.MethodGet, fmt.Sprintf("/v1/movies/%d", movie.ID), token, nil)
+	ts.request(t, http.MethodGet, fmt.Sprintf("/v1/movies/%d", movie.ID), token, nil)
+	ts.request(t, http.MethodGet, "/v1/movies/999", token, nil)
+	ts.request(t, http.MethodGet, "/no/such/path", "", nil)
+	ts.request(t, "FOO1", "/v1/movies", token, nil)
+
+	scrape := func(t *testing.T, username, password string) testResponse {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+
+		return ts.do(t, req)
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		for _, creds := range [][2]string{{"", ""}, {"prometheus", "wrong"}, {"admin", "s3cret"}} {
+			res := scrape(t, creds[0], creds[1])
+			assertStatus(t, res, http.StatusUnauthorized)
+
+			if got := res.headers.Get("WWW-Authenticate"); !strings.HasPrefix(got, "Basic") {
+				t.Errorf("got WWW-Authenticate %q; want a Basic challenge", got)
+			}
+		}
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		res := scrape(t, "prometheus", "s3cret")
+		assertStatus(t, res, http.StatusOK)
+
+		if got := res.headers.Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+			t.Errorf("got Content-Type %q; want the Prometheus text format", got)
+		}
+
+		for _, want := range []string{
+			`greenlight_build_info{version="` + version + `",`,
+			`greenlight_http_requests_total{method="GET",route="/v1/movies/:id",status="200"} 2`,
+			`greenlight_http_requests_total{method="GET",route="/v1/movies/:id",status="404"} 1`,
+			`greenlight_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+			`greenlight_http_requests_total{method="other",route="unmatched",status="405"} 1`,
+			`greenlight_http_request_duration_seconds_count{method="GET",route="/v1/movies/:id"} 3`,
+			`greenlight_rate_limit_rejections_total 0`,
+		} {
+			if !strings.Contains(string(res.body), want) {
+				t.Errorf("metrics do not contain %q:\n%s", want, res.body)
+			}
+		}
+	})
+
+	t.Run("Basic auth disabled without a password", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.ops.username = "prometheus"
+		ts := newTestServer(t, app)
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("prometheus", "")
+
+		res := ts.do(t, req)
+		assertStatus(t, res, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomasen/realip"
+
+	"github.com/rynhndrcksn/greenlight/internal/ratelimit"
+)
+
+// defaultRoutePolicies are the rate limits for the routes which need stricter limits than
+// the rest of the API, because they send emails or check passwords and tokens, and so
+// are targets for brute force attacks and abuse. They can be overridden with the
+// -limiter-policy flag. Each policy is named after its route.
+var defaultRoutePolicies = []ratelimit.Policy{
+	{Name: "POST /v1/tokens/authentication", Limit: 10, Window: time.Minute},
+	{Name: "POST /v1/tokens/mfa", Limit: 10, Window: time.Minute},
+	{Name: "POST /v1/tokens/oidc", Limit: 10, Window: time.Minute},
+	{Name: "POST /v1/tokens/activation", Limit: 5, Window: time.Hour},
+	{Name: "POST /v1/tokens/password-reset", Limit: 5, Window: time.Hour},
+	{Name: "POST /v1/users", Limit: 5, Window: time.Hour},
+	{Name: "PUT /v1/users/activated", Limit: 10, Window: time.Minute},
+	{Name: "PUT /v1/users/password", Limit: 10, Window: time.Minute},
+	{Name: "PATCH /v1/users/me", Limit: 10, Window: time.Minute},
+	{Name: "DELETE /v1/users/me", Limit: 10, Window: time.Minute},
+	{Name: "PUT /v1/users/me/email", Limit: 10, Window: time.Minute},
+}
+
+// defaultAuthenticationPolicy is the rate limit on requests with invalid credentials
+// from each IP address, which is checked before the credentials are looked up. Clients
+// with valid credentials never use it up, so it only needs to leave room for the odd
+// expired token. It can be overridden with the -limiter-auth-policy flag.
+var defaultAuthenticationPolicy = ratelimit.Policy{Name: "authentication", Limit: 60, Window: time.Minute}
+
+// parseRoutePolicy parses a rate limit policy for a route, in the form
+// "METHOD /pattern=limit/window", like "POST /v1/tokens/authentication=10/1m".
+func parseRoutePolicy(s string) (string, ratelimit.Policy, error) {
+	route, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", ratelimit.Policy{}, fmt.Errorf("invalid route policy %q: must be in the form \"METHOD /pattern=limit/window\"", s)
+	}
+
+	method, pattern, ok := strings.Cut(route, " ")
+	if !ok || method == "" || !strings.HasPrefix(pattern, "/") {
+		return "", ratelimit.Policy{}, fmt.Errorf("invalid route policy %q: route must be in the form \"METHOD /pattern\"", s)
+	}
+
+	policy, err := ratelimit.ParsePolicy(value)
+	if err != nil {
+		return "", ratelimit.Policy{}, err
+	}
+	policy.Name = route
+
+	return route, policy, nil
+}
+
+// rateLimitPolicy returns the policy for the route, which is the route's own policy if
+// it has one, and the default policy (built from -limiter-rps and -limiter-burst)
+// otherwise. The default policy is shared by every route without its own policy.
+func (app *application) rateLimitPolicy(route string) ratelimit.Policy {
+	if policy, ok := app.config.limiter.policies[route]; ok {
+		return policy
+	}
+
+	// A token bucket holding burst tokens which refills at rps tokens a second lets
+	// through burst requests every burst/rps seconds.
+	return ratelimit.Policy{
+		Name:   "default",
+		Limit:  app.config.limiter.burst,
+		Window: time.Duration(float64(app.config.limiter.burst) / app.config.limiter.rps * float64(time.Second)),
+	}
+}
+
+// rateLimitKey returns the key the client's requests are counted under: their user ID
+// if they're authenticated, so they get the same budget wherever they connect from, and
+// their IP address otherwise.
+func (app *application) rateLimitKey(r *http.Request) string {
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}
+
+	return "ip:" + realip.FromRequest(r)
+}
+
+// setRateLimitHeaders tells the client about its rate limit, using the RateLimit-*
+// headers from the IETF draft, plus Retry-After if the request was rejected.
+func setRateLimitHeaders(w http.ResponseWriter, policy ratelimit.Policy, result ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ratelimit.Seconds(result.Reset)))
+	w.Header().Set("RateLimit-Policy", policy.String())
+
+	if !result.Allowed {
+		// A Retry-After of zero would tell the client to retry straight away, which
+		// could be a fraction of a second too early.
+		w.Header().Set("Retry-After", strconv.Itoa(max(ratelimit.Seconds(result.RetryAfter), 1)))
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/ratelimit"
+)
+
+// failingLimiter is a ratelimit.Limiter whose backend is always unavailable.
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(ctx context.Context, key string, policy ratelimit.Policy) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("database is down")
+}
+
+func (failingLimiter) Peek(ctx context.Context, key string, policy ratelimit.Policy) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("database is down")
+}
+
+// newRateLimitedApplication returns a test application with the in-memory rate limiter
+// enabled, allowing bursts of two requests and one request a second by default.
+func newRateLimitedApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 2
+	app.limiter = ratelimit.NewMemoryLimiter()
+
+	return app
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Default policy", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newRateLimitedApplication(t))
+
+		for i := range 2 {
+			res := ts.request(t, http.MethodGet, "/v1/healthcheck", "", nil)
+			assertStatus(t, res, http.StatusOK)
+			assertHeaders(t, res, map[string]string{
+				"RateLimit-Limit":     "2",
+				"RateLimit-Remaining": []string{"1", "0"}[i],
+				"RateLimit-Policy":    "2;w=2",
+				"Retry-After":         "",
+			})
+		}
+
+		// Unknown routes count against the default policy too.
+		res := ts.request(t, http.MethodGet, "/v1/unknown", "", nil)
+		assertStatus(t, res, http.StatusTooManyRequests)
+		assertJSON(t, res, `{"error": "rate limit exceeded"}`)
+		assertHeaders(t, res, map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": "0",
+			"RateLimit-Reset":     "2",
+			"Retry-After":         "1",
+		})
+	})
+
+	t.Run("Route policy", func(t *testing.T) {
+		t.Parallel()
+
+		app := newRateLimitedApplication(t)
+		app.config.limiter.policies = map[string]ratelimit.Policy{
+			"POST /v1/tokens/authentication": {Name: "POST /v1/tokens/authentication", Limit: 1, Window: time.Minute},
+		}
+		ts := newTestServer(t, app)
+
+		body := map[string]any{"email": "alice@example.com", "password": "pa55word1234"}
+
+		res := ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", body)
+		assertStatus(t, res, http.StatusUnauthorized)
+		assertHeaders(t, res, map[string]string{"RateLimit-Policy": "1;w=60"})
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", body)
+		assertStatus(t, res, http.StatusTooManyRequests)
+		assertHeaders(t, res, map[string]string{"Retry-After": "60"})
+
+		// The route's budget is separate from the default one.
+		res = ts.request(t, http.MethodGet, "/v1/healthcheck", "", nil)
+		assertStatus(t, res, http.StatusOK)
+		assertHeaders(t, res, map[string]string{"RateLimit-Policy": "2;w=2", "RateLimit-Remaining": "1"})
+	})
+
+	t.Run("Authenticated users", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newTestServer(t, newRateLimitedApplication(t))
+
+		alice := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+		bob := ts.insertUser(t, "bob@example.com", "pa55word1234", true)
+		aliceToken := ts.newToken(t, alice, data.ScopeAuthentication)
+		bobToken := ts.newToken(t, bob, data.ScopeAuthentication)
+
+		for range 2 {
+			res := ts.request(t, http.MethodGet, "/v1/healthcheck", aliceToken, nil)
+			assertStatus(t, res, http.StatusOK)
+		}
+		res := ts.request(t, http.MethodGet, "/v1/healthcheck", aliceToken, nil)
+		assertStatus(t, res, http.StatusTooManyRequests)
+
+		// Other users and anonymous clients from the same address have their own
+		// budgets.
+		res = ts.request(t, http.MethodGet, "/v1/healthcheck", bobToken, nil)
+		assertStatus(t, res, http.StatusOK)
+		res = ts.request(t, http.MethodGet, "/v1/healthcheck", "", nil)
+		assertStatus(t, res, http.StatusOK)
+	})
+
+	t.Run("Invalid credentials", func(t *testing.T) {
+		t.Parallel()
+
+		app := newRateLimitedApplication(t)
+		app.config.limiter.authPolicy = ratelimit.Policy{Name: "authentication", Limit: 2, Window: time.Minute}
+		ts := newTestServer(t, app)
+
+		// Requests with valid credentials don't count against the address's budget, so
+		// users sharing an address don't share it.
+		for _, email := range []string{"alice@example.com", "bob@example.com"} {
+			token := ts.newToken(t, ts.insertUser(t, email, "pa55word1234", true), data.ScopeAuthentication)
+			for range 2 {
+				res := ts.request(t, http.MethodGet, "/v1/healthcheck", token, nil)
+				assertStatus(t, res, http.StatusOK)
+			}
+		}
+
+		// Requests with made-up credentials are rejected by authenticate, but count
+		// against the address's budget.
+		for _, token := range []string{"ABCDEFGHIJKLMNOPQRSTUVWXYZ", data.APIKeyPrefix + "guess"} {
+			res := ts.request(t, http.MethodGet, "/v1/healthcheck", token, nil)
+			assertStatus(t, res, http.StatusUnauthorized)
+		}
+
+		res := ts.request(t, http.MethodGet, "/v1/healthcheck", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", nil)
+		assertStatus(t, res, http.StatusTooManyRequests)
+		assertHeaders(t, res, map[string]string{"RateLimit-Policy": "2;w=60", "Retry-After": "30"})
+
+		// Anonymous requests aren't affected.
+		res = ts.request(t, http.MethodGet, "/v1/healthcheck", "", nil)
+		assertStatus(t, res, http.StatusOK)
+	})
+
+	t.Run("Limiter failure", func(t *testing.T) {
+		t.Parallel()
+
+		app := newRateLimitedApplication(t)
+		app.limiter = failingLimiter{}
+		ts := newTestServer(t, app)
+
+		// Requests are let through when the limiter is unavailable.
+		res := ts.request(t, http.MethodGet, "/v1/healthcheck", "", nil)
+		assertStatus(t, res, http.StatusOK)
+		assertHeaders(t, res, map[string]string{"RateLimit-Limit": ""})
+	})
+}
+
+func TestParseRoutePolicy(t *testing.T) {
+	t.Parallel()
+
+	route, policy, err := parseRoutePolicy("POST /v1/tokens/authentication=3/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ratelimit.Policy{Name: "POST /v1/tokens/authentication", Limit: 3, Window: time.Minute}
+	if route != want.Name || policy != want {
+		t.Errorf("got %q, %+v; want %q, %+v", route, policy, want.Name, want)
+	}
+
+	for _, input := range []string{"POST /v1/tokens/authentication", "/v1/movies=3/1m", "GET v1/movies=3/1m", "GET /v1/movies=3"} {
+		if _, _, err := parseRoutePolicy(input); err == nil {
+			t.Errorf("got no error for %q; want one", input)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestRoles(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	admin := ts.insertUser(t, "admin@example.com", "pa55word1234", true, "roles:manage")
+	adminToken := ts.newToken(t, admin, data.ScopeAuthentication)
+
+	alice := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	aliceToken := ts.newToken(t, alice, data.ScopeAuthentication)
+
+	t.Run("Not permitted", func(t *testing.T) {
+		res := ts.request(t, http.MethodGet, "/v1/admin/roles", aliceToken, nil)
+		assertStatus(t, res, http.StatusForbidden)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		res := ts.request(t, http.MethodGet, "/v1/admin/roles", adminToken, nil)
+
+		assertStatus(t, res, http.StatusOK)
+		assertJSON(t, res, `{"roles": [
+			{"id": 3, "name": "admin", "permissions": ["debug:read", "movies:read", "movies:write", "roles:manage", "users:manage"]},
+			{"id": 2, "name": "editor", "permissions": ["movies:read", "movies:write"]},
+			{"id": 1, "name": "viewer", "permissions": ["movies:read"]}
+		]}`)
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		body := map[string]any{"name": "curator", "permissions": []string{"movies:write", "movies:read"}}
+		res := ts.request(t, http.MethodPost, "/v1/admin/roles", adminToken, body)
+
+		assertStatus(t, res, http.StatusCreated)
+		assertJSON(t, res, `{"role": {"id": 4, "name": "curator", "permissions": ["movies:read", "movies:write"]}}`)
+
+		res = ts.request(t, http.MethodPost, "/v1/admin/roles", adminToken, body)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"name": "a role with this name already exists"}}`)
+	})
+
+	t.Run("Create invalid", func(t *testing.T) {
+		body := map[string]any{"name": "", "permissions": []string{"movies:read", "movies:delete"}}
+		res := ts.request(t, http.MethodPost, "/v1/admin/roles", adminToken, body)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {
+			"name": "must be provided",
+			"permissions": "must only contain known permission codes"
+		}}`)
+	})
+}
+
+func TestUserRoles(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	admin := ts.insertUser(t, "admin@example.com", "pa55word1234", true, "roles:manage")
+	adminToken := ts.newToken(t, admin, data.ScopeAuthentication)
+
+	alice := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	aliceToken := ts.newToken(t, alice, data.ScopeAuthentication)
+
+	res := ts.request(t, http.MethodPost, "/v1/movies", aliceToken, map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}})
+	assertStatus(t, res, http.StatusForbidden)
+
+	// Making alice an editor lets her create movies.
+	res = ts.request(t, http.MethodPost, "/v1/admin/users/2/roles", adminToken, map[string]any{"roles": []string{"editor"}})
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"roles": ["editor"]}`)
+
+	res = ts.request(t, http.MethodPost, "/v1/movies", aliceToken, map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}})
+	assertStatus(t, res, http.StatusCreated)
+
+	// Her effective permissions combine the direct grant with the role's grants.
+	res = ts.request(t, http.MethodGet, "/v1/admin/users/2/permissions", adminToken, nil)
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"roles": ["editor"], "permissions": ["movies:read", "movies:write"]}`)
+
+	// Removing the role takes the permissions away again.
+	res = ts.request(t, http.MethodDelete, "/v1/admin/users/2/roles/editor", adminToken, nil)
+	assertStatus(t, res, http.StatusOK)
+
+	res = ts.request(t, http.MethodGet, "/v1/admin/users/2/permissions", adminToken, nil)
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"roles": [], "permissions": ["movies:read"]}`)
+
+	res = ts.request(t, http.MethodDelete, "/v1/admin/users/2/roles/editor", adminToken, nil)
+	assertStatus(t, res, http.StatusNotFound)
+
+	t.Run("Invalid roles", func(t *testing.T) {
+		res := ts.request(t, http.MethodPost, "/v1/admin/users/2/roles", adminToken, map[string]any{"roles": []string{"editor", "owner"}})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"roles": "must only contain known role names"}}`)
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		res := ts.request(t, http.MethodPost, "/v1/admin/users/99/roles", adminToken, map[string]any{"roles": []string{"editor"}})
+		assertStatus(t, res, http.StatusNotFound)
+
+		res = ts.request(t, http.MethodGet, "/v1/admin/users/99/permissions", adminToken, nil)
+		assertStatus(t, res, http.StatusNotFound)
+	})
+}
+
+func TestRegisteredUsersAreViewers(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	body := map[string]any{"name": "Alice Smith", "email": "alice@example.com", "password": "pa55word1234"}
+	res := ts.request(t, http.MethodPost, "/v1/users", "", body)
+	assertStatus(t, res, http.StatusAccepted)
+
+	roles, err := app.models.Roles.GetAllForUser(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0] != data.RoleViewer {
+		t.Errorf("got roles %v; want [viewer]", roles)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"net/http/pprof"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -11,23 +12,109 @@ func (app *application) routes() http.Handler {
 	// Initialize new httprouter instance
 	router := httprouter.New()
 
-	// Tell httprouter to use our custom notFoundResponse handler.
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	// Tell httprouter to use our custom notFoundResponse handler. Requests for unknown
+	// routes still count against the default rate limit.
+	router.NotFound = app.rateLimit("", app.notFoundResponse)
 
 	// Tell httprouter to use our custom methodNotAllowed handler.
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	router.MethodNotAllowed = app.rateLimit("", app.methodNotAllowedResponse)
+
+	// handle registers a handler for a route, rate limited with the route's policy. It
+	// records the route's pattern when it's matched so the metrics can group requests by
+	// route rather than by their raw path.
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		handler = app.rateLimit(method+" "+pattern, handler)
+		router.HandlerFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+			if info := contextGetRequestInfo(r.Context()); info != nil {
+				info.route = pattern
+			}
+			handler(w, r)
+		})
+	}
 
 	// Register /v1/ routes
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
-
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthz", app.livenessHandler)
+	handle(http.MethodGet, "/v1/readyz", app.readinessHandler)
+	handle(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	handle(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	handle(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	handle(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	handle(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	handle(http.MethodPost, "/v1/users", app.registerUserHandler)
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	handle(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	handle(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showProfileHandler))
+	handle(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.forbidAPIKeys(app.updateProfileHandler)))
+	handle(http.MethodDelete, "/v1/users/me", app.requireActivatedUser(app.forbidAPIKeys(app.deleteProfileHandler)))
+	handle(http.MethodPut, "/v1/users/me/email", app.confirmEmailChangeHandler)
+	handle(http.MethodPost, "/v1/users/mfa/totp", app.requireActivatedUser(app.forbidAPIKeys(app.beginMFAHandler)))
+	handle(http.MethodPut, "/v1/users/mfa/totp", app.requireActivatedUser(app.forbidAPIKeys(app.confirmMFAHandler)))
+	handle(http.MethodDelete, "/v1/users/mfa/totp", app.requireActivatedUser(app.forbidAPIKeys(app.disableMFAHandler)))
+	handle(http.MethodGet, "/v1/api-keys", app.requireActivatedUser(app.forbidAPIKeys(app.listAPIKeysHandler)))
+	handle(http.MethodPost, "/v1/api-keys", app.requireActivatedUser(app.forbidAPIKeys(app.createAPIKeyHandler)))
+	handle(http.MethodDelete, "/v1/api-keys/:id", app.requireActivatedUser(app.forbidAPIKeys(app.deleteAPIKeyHandler)))
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.forbidAPIKeys(app.deleteAuthenticationTokenHandler)))
+	handle(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/refresh", app.refreshTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	handle(http.MethodDelete, "/v1/tokens", app.requireAuthenticatedUser(app.forbidAPIKeys(app.deleteAllTokensHandler)))
+	// OpenID Connect logins are only available when a provider has been configured.
+	if app.oidc != nil {
+		handle(http.MethodPost, "/v1/oidc/authorize", app.createOIDCAuthorizationHandler)
+		handle(http.MethodPost, "/v1/tokens/oidc", app.createOIDCAuthenticationTokenHandler)
+	}
+	handle(http.MethodGet, "/v1/admin/roles", app.requirePermission("roles:manage", app.listRolesHandler))
+	handle(http.MethodPost, "/v1/admin/roles", app.requirePermission("roles:manage", app.createRoleHandler))
+	handle(http.MethodGet, "/v1/admin/users", app.requirePermission("users:manage", app.listUsersHandler))
+	handle(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:manage", app.showUserHandler))
+	handle(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:manage", app.updateUserHandler))
+	handle(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:manage", app.deleteUserHandler))
+	handle(http.MethodPost, "/v1/admin/users/:id/unlock", app.requirePermission("users:manage", app.unlockUserHandler))
+	handle(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:manage", app.grantUserPermissionsHandler))
+	handle(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:manage", app.revokeUserPermissionHandler))
+	handle(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("roles:manage", app.addUserRolesHandler))
+	handle(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("roles:manage", app.removeUserRoleHandler))
+	handle(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("roles:manage", app.showUserPermissionsHandler))
+
+	api := app.logRequest(app.metrics(app.recoverPanic(app.enableCORS(app.rateLimitAuthentication(app.authenticate(router))))))
+
+	// If there's a separate ops server, that serves the ops endpoints instead.
+	if app.config.ops.port != 0 {
+		return api
+	}
+
+	// Otherwise serve them here, but only to clients with the ops credentials or the
+	// "debug:read" permission. They're kept out of the API's middleware chain (which
+	// would reject basic auth credentials) and out of the metrics.
+	ops := app.logRequest(app.recoverPanic(app.requireOpsAccess(app.opsRoutes())))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", ops)
+	mux.Handle("/debug/", ops)
+	mux.Handle("/", api)
+
+	return mux
+}
+
+// opsRoutes returns the handler for the endpoints used to operate the server: the
+// Prometheus metrics, expvar, pprof and the health checks. They're served by the ops
+// server when it's enabled, and from the API otherwise.
+func (app *application) opsRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", app.prometheus.registry)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /healthcheck", app.healthcheckHandler)
+	mux.HandleFunc("GET /healthz", app.livenessHandler)
+	mux.HandleFunc("GET /readyz", app.readinessHandler)
+
+	return mux
 }
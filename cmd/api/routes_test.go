@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestNotFound(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	res := ts.request(t, http.MethodGet, "/v1/does-not-exist", "", nil)
+
+	assertStatus(t, res, http.StatusNotFound)
+	assertJSON(t, res, `{"error": "the requested resource could not be found"}`)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	res := ts.request(t, http.MethodPut, "/v1/healthcheck", "", nil)
+
+	assertStatus(t, res, http.StatusMethodNotAllowed)
+	assertJSON(t, res, `{"error": "the PUT method is not supported for this resource"}`)
+
+	if allow := res.headers.Get("Allow"); allow == "" {
+		t.Error("missing Allow header")
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "debug:read")
+	token := ts.newToken(t, user, data.ScopeAuthentication)
+
+	// Send a request first so the metrics middleware has something to count.
+	ts.request(t, http.MethodGet, "/v1/healthcheck", "", nil)
+	res := ts.request(t, http.MethodGet, "/debug/vars", token, nil)
+
+	assertStatus(t, res, http.StatusOK)
+
+	var vars map[string]json.RawMessage
+	decodeJSON(t, res, &vars)
+
+	for _, name := range []string{"total_requests_received", "total_responses_sent", "total_responses_sent_by_status"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("missing %q in /debug/vars", name)
+		}
+	}
+}
+
+func TestOpsAccess(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	app.config.ops.username = "ops"
+	app.config.ops.password = "s3cret"
+	ts := newTestServer(t, app)
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	token := ts.newToken(t, user, data.ScopeAuthentication)
+
+	opsRequest := func(t *testing.T, path, token, username, password string) testResponse {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+
+		return ts.do(t, req)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		username   string
+		password   string
+		wantStatus int
+	}{
+		{name: "Anonymous", path: "/debug/vars", wantStatus: http.StatusUnauthorized},
+		{name: "Without permission", path: "/debug/vars", token: token, wantStatus: http.StatusForbidden},
+		{name: "Wrong password", path: "/debug/vars", username: "ops", password: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "Basic auth", path: "/debug/vars", username: "ops", password: "s3cret", wantStatus: http.StatusOK},
+		{name: "pprof", path: "/debug/pprof/", username: "ops", password: "s3cret", wantStatus: http.StatusOK},
+		{name: "Metrics", path: "/metrics", username: "ops", password: "s3cret", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := opsRequest(t, tt.path, tt.token, tt.username, tt.password)
+			assertStatus(t, res, tt.wantStatus)
+		})
+	}
+
+	t.Run("Separate ops server", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.ops.port = 4001
+
+		// With an ops server, the API doesn't serve the ops endpoints at all...
+		ts := newTestServer(t, app)
+		for _, path := range []string{"/debug/vars", "/metrics"} {
+			res := ts.request(t, http.MethodGet, path, "", nil)
+			assertStatus(t, res, http.StatusNotFound)
+		}
+
+		// ...and the ops server serves them without any authentication.
+		ops := httptest.NewServer(app.opsRoutes())
+		t.Cleanup(ops.Close)
+
+		for _, path := range []string{"/debug/vars", "/debug/pprof/", "/metrics", "/healthcheck", "/healthz", "/readyz"} {
+			res, err := ops.Client().Get(ops.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("got status %d for %s; want %d", res.StatusCode, path, http.StatusOK)
+			}
+		}
+	})
+}
@@ -5,15 +5,31 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
 
 // serve sets up a new http.Server and calls .ListenAndServe on it.
 func (app *application) serve() error {
+	// Create a base context for every request. Each request's context (and so every
+	// database query made with it) is derived from this, which means cancelling it
+	// cancels anything still in flight.
+	baseCtx, cancelBaseCtx := context.WithCancel(context.Background())
+	defer cancelBaseCtx()
+
+	// Start the worker which sends the emails in the outbox, and the one which deletes
+	// old failed logins. They're stopped once the server has shut down, and anything
+	// still in the outbox is sent when the server next starts.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	app.background(func() { app.runOutbox(workerCtx) })
+	app.background(func() { app.runLoginFailurePruner(workerCtx) })
+
 	// Initialize HTTP server using some sensible timeout settings.
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
@@ -22,6 +38,38 @@ func (app *application) serve() error {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
+	}
+
+	// Start the ops server, if it's enabled. We open its listener here so that we find
+	// out straight away if the port can't be used. The ops server doesn't authenticate
+	// requests, so it only listens on loopback unless it's told otherwise.
+	var opsSrv *http.Server
+	if app.config.ops.port != 0 {
+		opsSrv = &http.Server{
+			Addr:        net.JoinHostPort(app.config.ops.host, strconv.Itoa(app.config.ops.port)),
+			Handler:     app.opsRoutes(),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 5 * time.Second,
+			// CPU profiles and traces take 30 seconds by default, so allow plenty of
+			// time for writing responses.
+			WriteTimeout: 2 * time.Minute,
+			ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+
+		ln, err := net.Listen("tcp", opsSrv.Addr)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			err := opsSrv.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error(err.Error(), slog.String("addr", opsSrv.Addr))
+			}
+		}()
+
+		app.logger.Info("starting ops server", slog.String("addr", opsSrv.Addr))
 	}
 
 	// Create a shutdownError channel used to receive any errors
@@ -46,6 +94,11 @@ func (app *application) serve() error {
 		// in the log entry attributes.
 		app.logger.Info("shutting down server", slog.String("signal", s.String()))
 
+		// Report that we're not ready any more. The API stops accepting connections as
+		// soon as Shutdown() is called, but the ops server keeps answering the readiness
+		// probe until the API has finished shutting down.
+		app.shuttingDown.Store(true)
+
 		// Create a context with a 30-second timeout.
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -56,6 +109,21 @@ func (app *application) serve() error {
 		// because the shutdown didn't complete before the 30-second context deadline is hit).
 		// We relay this return value to the shutdownError channel.
 		err := srv.Shutdown(ctx)
+
+		// Once Shutdown() has returned, any requests which are still running missed the
+		// deadline, so cancel their contexts to abort any queries they're waiting on.
+		cancelBaseCtx()
+		stopWorkers()
+
+		// Keep the ops server running until the API has shut down, so that it can still
+		// be monitored in the meantime.
+		if opsSrv != nil {
+			opsErr := opsSrv.Shutdown(ctx)
+			if err == nil {
+				err = opsErr
+			}
+		}
+
 		if err != nil {
 			shutdownError <- err
 		}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/mailer"
+)
+
+// newTestApplication returns an application configured for testing. It uses the
+// in-memory models behind the same caches as production (so the tests also check that
+// writes invalidate them), the in-memory email backend and a logger which discards
+// everything.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var conf config
+	conf.env = "testing"
+	conf.limiter.enabled = false
+	conf.limiter.authPolicy = defaultAuthenticationPolicy
+	conf.cursor.secret = []byte("test-cursor-secret")
+	conf.outbox.maxAttempts = 3
+	conf.health.timeout = time.Second
+	conf.login.maxFailures = 5
+	conf.login.ipMaxFailures = 50
+	conf.login.lockout = 15 * time.Minute
+	conf.login.window = time.Hour
+
+	return &application{
+		config: conf,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewCaches(100, time.Minute).Wrap(data.NewMemoryModels()),
+		mailer: mailer.NewMemorySender(),
+
+		prometheus: newPrometheusMetrics(),
+	}
+}
+
+// testServer wraps an httptest.Server which serves the application routes.
+type testServer struct {
+	*httptest.Server
+	app *application
+}
+
+// newTestServer starts a new test server for the application, which is closed
+// automatically when the test finishes.
+func newTestServer(t *testing.T, app *application) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(app.routes())
+	t.Cleanup(ts.Close)
+
+	return &testServer{Server: ts, app: app}
+}
+
+// testResponse holds the parts of a response which the tests assert against.
+type testResponse struct {
+	status  int
+	headers http.Header
+	body    []byte
+}
+
+// request sends a request to the test server. If token is not empty it's sent as a
+// bearer token, and if body is not nil it's encoded as JSON (unless it's already a
+// string, in which case it's sent as is).
+func (ts *testServer) request(t *testing.T, method, urlPath, token string, body any) testResponse {
+	t.Helper()
+
+	var reqBody io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reqBody = bytes.NewBufferString(b)
+	default:
+		js, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewBuffer(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return ts.do(t, req)
+}
+
+// do sends a prepared request to the test server and reads the response.
+func (ts *testServer) do(t *testing.T, req *http.Request) testResponse {
+	t.Helper()
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for any background tasks started by the request, then send any emails it
+	// queued, in place of the outbox worker.
+	ts.app.wg.Wait()
+
+	err = ts.app.processOutbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testResponse{status: res.StatusCode, headers: res.Header, body: resBody}
+}
+
+// hashedPasswords caches a user with each plaintext password that has been set, so
+// that the tests only pay the cost of hashing each password with bcrypt once.
+var hashedPasswords = struct {
+	sync.Mutex
+	users map[string]*data.User
+}{users: make(map[string]*data.User)}
+
+// insertUser adds a user directly to the models, granting them the given permissions.
+func (ts *testServer) insertUser(t *testing.T, email, password string, activated bool, permissions ...string) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: "Test User", Email: email, Activated: activated}
+
+	hashedPasswords.Lock()
+	hashed, ok := hashedPasswords.users[password]
+	if !ok {
+		hashed = &data.User{}
+		err := hashed.Password.Set(password)
+		if err != nil {
+			hashedPasswords.Unlock()
+			t.Fatal(err)
+		}
+		hashedPasswords.users[password] = hashed
+	}
+	user.Password = hashed.Password
+	hashedPasswords.Unlock()
+
+	err := ts.app.models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		err = ts.app.models.Permissions.AddForUser(context.Background(), user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return user
+}
+
+// newToken creates a token for the user directly in the models.
+func (ts *testServer) newToken(t *testing.T, user *data.User, scope string) string {
+	t.Helper()
+
+	token, err := ts.app.models.Tokens.New(context.Background(), user.ID, time.Hour, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Plaintext
+}
+
+// insertMovie adds a movie directly to the models.
+func (ts *testServer) insertMovie(t *testing.T, title string, year int32, runtime data.Runtime, genres ...string) *data.Movie {
+	t.Helper()
+
+	movie := &data.Movie{Title: title, Year: year, Runtime: runtime, Genres: genres}
+
+	err := ts.app.models.Movies.Insert(context.Background(), movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return movie
+}
+
+// sentEmails returns the emails captured by the application's in-memory email backend.
+func sentEmails(app *application) []mailer.Email {
+	return app.mailer.(*mailer.MemorySender).Sent()
+}
+
+// emailData returns the value for key in the data the email was rendered with.
+func emailData(email mailer.Email, key string) any {
+	d, _ := email.Data.(map[string]any)
+	return d[key]
+}
+
+// assertStatus checks the response status code.
+func assertStatus(t *testing.T, res testResponse, want int) {
+	t.Helper()
+
+	if res.status != want {
+		t.Fatalf("got status %d; want %d; body: %s", res.status, want, res.body)
+	}
+}
+
+// assertHeaders checks the values of the response headers. An empty value checks that
+// the header isn't set.
+func assertHeaders(t *testing.T, res testResponse, want map[string]string) {
+	t.Helper()
+
+	for name, value := range want {
+		if got := res.headers.Get(name); got != value {
+			t.Errorf("got %s header %q; want %q", name, got, value)
+		}
+	}
+}
+
+// assertJSON checks that the response body contains exactly the JSON in want,
+// ignoring formatting and the order of object keys.
+func assertJSON(t *testing.T, res testResponse, want string) {
+	t.Helper()
+
+	var got, expected any
+
+	err := json.Unmarshal(res.body, &got)
+	if err != nil {
+		t.Fatalf("response body is not valid JSON: %v; body: %s", err, res.body)
+	}
+
+	err = json.Unmarshal([]byte(want), &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got body %s; want %s", res.body, want)
+	}
+}
+
+// decodeJSON decodes the response body into dst.
+func decodeJSON(t *testing.T, res testResponse, dst any) {
+	t.Helper()
+
+	err := json.Unmarshal(res.body, dst)
+	if err != nil {
+		t.Fatalf("response body is not valid JSON: %v; body: %s", err, res.body)
+	}
+}
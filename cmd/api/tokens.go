@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/rynhndrcksn/greenlight/internal/data"
@@ -34,14 +37,26 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Refuse to check the password at all if the account or the client's IP address is
+	// locked out, or if the client is trying again too soon after a failed login.
+	retryAfter, err := app.loginRetryAfter(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
-	// Unauthorized response to the client (we will create this helper in a moment).
-	user, err := app.models.Users.GetByEmail(input.Email)
+	// found, then we record the failure and call the app.invalidCredentialsResponse()
+	// helper to send a 401 Unauthorized response to the client.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.failedLoginResponse(w, r, input.Email, nil)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -55,24 +70,397 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
+	// If the passwords don't match, then we record the failure and send a 401
+	// Unauthorized response again.
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.failedLoginResponse(w, r, input.Email, user)
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// Users who have been deactivated by an admin can't log in, but they only find out
+	// once they've got the password right.
+	if user.Deactivated() {
+		app.deactivatedAccountResponse(w, r)
+		return
+	}
+
+	// If the user has two-factor authentication enabled, the password isn't enough.
+	// Instead of the session tokens, send a short-lived mfa-pending token which the
+	// client exchanges for them along with a code at POST /v1/tokens/mfa. The earlier
+	// failures for the account aren't forgotten until then, so that someone who knows
+	// the password can't keep resetting them while guessing codes.
+	mfaEnabled, err := app.mfaEnabled(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if mfaEnabled {
+		app.mfaRequiredResponse(w, r, user.ID)
+		return
+	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	// The password was right, so forget any earlier failures for the account.
+	err = app.resetLoginFailures(r.Context(), input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Otherwise, if the password is correct, we generate a new authentication token
+	// and refresh token for the user.
+	env, err := app.createSessionTokens(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createSessionTokens generates a new authentication token and a refresh token with a
+// 30-day expiry time for the user, and returns them in an envelope ready to be sent to
+// the client. The authentication token is either an opaque token with a 24-hour expiry
+// time, or a short-lived signed access token, depending on the configuration.
+func (app *application) createSessionTokens(ctx context.Context, userID int64) (envelope, error) {
+	var (
+		authenticationToken *data.Token
+		err                 error
+	)
+	if app.config.accessTokens.keys != nil {
+		authenticationToken, err = app.signAccessToken(ctx, userID)
+	} else {
+		authenticationToken, err = app.models.Tokens.New(ctx, userID, 24*time.Hour, data.ScopeAuthentication)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := app.models.Tokens.New(ctx, userID, 30*24*time.Hour, data.ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"authentication_token": authenticationToken, "refresh_token": refreshToken}, nil
+}
+
+// signAccessToken returns a new signed access token for the user, holding their current
+// activation state and permissions.
+func (app *application) signAccessToken(ctx context.Context, userID int64) (*data.Token, error) {
+	user, err := app.models.Users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return app.config.accessTokens.keys.Sign(data.AccessTokenClaims{
+		UserID:      user.ID,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		IssuedAt:    now,
+		Expiry:      now.Add(app.config.accessTokens.ttl),
+	}), nil
+}
+
+// refreshTokenHandler exchanges a refresh token for a new authentication token and
+// refresh token. Refresh tokens are rotated, meaning each one can only be used once.
+// If a refresh token is presented again after it has been used, we assume it has been
+// stolen and revoke all the user's sessions.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// The refresh token has the same format as every other token, but we report any
+	// problems against the "refresh_token" key.
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, map[string]string{"refresh_token": v.Errors["token"]})
+		return
+	}
+
+	token, err := app.models.Tokens.Get(r.Context(), data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("refresh_token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Mark the token as used. If it had already been used (including by a concurrent
+	// request which got there first) then treat it as a replay.
+	reused := token.UsedAt != nil
+	if !reused {
+		err = app.models.Tokens.MarkUsed(r.Context(), token)
+		reused = errors.Is(err, data.ErrEditConflict)
+	}
+	if reused {
+		app.logger.WarnContext(r.Context(), "refresh token reused, revoking all sessions", slog.Int64("user_id", token.UserId))
+
+		err = app.revokeSessions(r.Context(), token.UserId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		v.AddError("refresh_token", "invalid or expired refresh token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env, err := app.createSessionTokens(r.Context(), token.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler logs the user out by revoking the authentication
+// token used to make the request. The client can optionally send the refresh token
+// for the session in the request body, in which case we revoke that as well.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// The request body is optional, so only try to read it if there is one.
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	user := app.contextGetUser(r)
+
+	// The authenticate middleware has already checked the Authorization header, so we
+	// know it contains a valid bearer token. Signed access tokens aren't stored, so
+	// they can't be revoked and just won't be found here; they stay valid until they
+	// expire.
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	err := app.models.Tokens.Delete(r.Context(), data.ScopeAuthentication, token)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only revoke the refresh token if it belongs to the same user.
+	if input.RefreshToken != "" {
+		refreshToken, err := app.models.Tokens.Get(r.Context(), data.ScopeRefresh, input.RefreshToken)
+		switch {
+		case err == nil && refreshToken.UserId == user.ID:
+			err = app.models.Tokens.Delete(r.Context(), data.ScopeRefresh, input.RefreshToken)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllTokensHandler logs the user out everywhere by revoking all of their
+// authentication and refresh tokens.
+func (app *application) deleteAllTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeSessions deletes all the authentication and refresh tokens for a user.
+func (app *application) revokeSessions(ctx context.Context, userID int64) error {
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(ctx, scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createPasswordResetTokenHandler generates a password reset token and emails it to the
+// user. To avoid revealing which email addresses have accounts, we send the same
+// response whether or not a matching, activated user was found.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if a matching account exists, an email will be sent to you containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only activated users can reset their password.
+	if user.Activated {
+		err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+			token, err := tx.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+			if err != nil {
+				return err
+			}
+
+			return tx.Outbox.Enqueue(r.Context(), &data.Email{
+				Recipient: user.Email,
+				Locale:    user.PreferredLocale,
+				Template:  "token_password_reset.tmpl",
+				Data:      map[string]any{"passwordResetToken": token.Plaintext},
+			})
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.wakeOutbox()
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler sends a fresh activation token to a user who has never
+// activated their account, replacing any activation tokens they already have. Users
+// who have been deactivated by an admin aren't sent one. Like the password reset
+// endpoint, the response doesn't reveal whether the email exists.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if a matching account needs activating, an email will be sent to you containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated && !user.Deactivated() {
+		// Delete any previous activation tokens so only the newest one is valid, and
+		// queue the email with the new one.
+		err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+			err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+			if err != nil {
+				return err
+			}
+
+			token, err := tx.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+			if err != nil {
+				return err
+			}
+
+			return tx.Outbox.Enqueue(r.Context(), &data.Email{
+				Recipient: user.Email,
+				Locale:    user.PreferredLocale,
+				Template:  "token_activation.tmpl",
+				Data:      map[string]any{"activationToken": token.Plaintext},
+			})
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.wakeOutbox()
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestCreateAuthenticationToken(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+
+	t.Run("Valid credentials", func(t *testing.T) {
+		res := ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+
+		assertStatus(t, res, http.StatusCreated)
+
+		var got struct {
+			Token struct {
+				Token  string    `json:"token"`
+				Expiry time.Time `json:"expiry"`
+			} `json:"authentication_token"`
+			RefreshToken struct {
+				Token  string    `json:"token"`
+				Expiry time.Time `json:"expiry"`
+			} `json:"refresh_token"`
+		}
+		decodeJSON(t, res, &got)
+
+		if got.Token.Expiry.Before(time.Now().Add(23 * time.Hour)) {
+			t.Errorf("got expiry %v; want about 24 hours from now", got.Token.Expiry)
+		}
+		if got.RefreshToken.Expiry.Before(time.Now().Add(29 * 24 * time.Hour)) {
+			t.Errorf("got refresh token expiry %v; want about 30 days from now", got.RefreshToken.Expiry)
+		}
+
+		authenticated, err := app.models.Users.GetForToken(context.Background(), data.ScopeAuthentication, got.Token.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authenticated.ID != user.ID {
+			t.Errorf("got token for user %d; want %d", authenticated.ID, user.ID)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Wrong password",
+			email:      "alice@example.com",
+			password:   "wrongpa55word",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `{"error": "invalid authentication credentials"}`,
+		},
+		{
+			name:       "Unknown email",
+			email:      "bob@example.com",
+			password:   "pa55word1234",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `{"error": "invalid authentication credentials"}`,
+		},
+		{
+			name:       "Invalid input",
+			email:      "",
+			password:   "",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `{"error": {"email": "must be provided", "password": "must be provided"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": tt.email, "password": tt.password})
+
+			assertStatus(t, res, tt.wantStatus)
+			assertJSON(t, res, tt.wantBody)
+		})
+	}
+}
+
+// sessionTokens holds the tokens returned when logging in or refreshing a session.
+type sessionTokens struct {
+	AuthenticationToken struct {
+		Token  string    `json:"token"`
+		Expiry time.Time `json:"expiry"`
+	} `json:"authentication_token"`
+	RefreshToken struct {
+		Token string `json:"token"`
+	} `json:"refresh_token"`
+}
+
+func TestRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	refreshToken := ts.newToken(t, user, data.ScopeRefresh)
+
+	// Exchange the refresh token for a new pair of tokens.
+	res := ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": refreshToken})
+	assertStatus(t, res, http.StatusCreated)
+
+	var rotated sessionTokens
+	decodeJSON(t, res, &rotated)
+
+	if rotated.RefreshToken.Token == refreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	res = ts.request(t, http.MethodGet, "/v1/movies", rotated.AuthenticationToken.Token, nil)
+	assertStatus(t, res, http.StatusOK)
+
+	// A refresh token can't be used as an authentication token.
+	res = ts.request(t, http.MethodGet, "/v1/movies", rotated.RefreshToken.Token, nil)
+	assertStatus(t, res, http.StatusUnauthorized)
+
+	// Replaying the original refresh token is rejected and revokes every session,
+	// including the one created by the legitimate rotation above.
+	res = ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": refreshToken})
+	assertStatus(t, res, http.StatusUnprocessableEntity)
+	assertJSON(t, res, `{"error": {"refresh_token": "invalid or expired refresh token"}}`)
+
+	res = ts.request(t, http.MethodGet, "/v1/movies", rotated.AuthenticationToken.Token, nil)
+	assertStatus(t, res, http.StatusUnauthorized)
+
+	res = ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": rotated.RefreshToken.Token})
+	assertStatus(t, res, http.StatusUnprocessableEntity)
+
+	t.Run("Malformed token", func(t *testing.T) {
+		res := ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": "abc"})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"refresh_token": "must be 26 bytes long"}}`)
+	})
+}
+
+func TestSignedAccessTokens(t *testing.T) {
+	t.Parallel()
+
+	newSignedApplication := func(t *testing.T, keys string) *application {
+		t.Helper()
+
+		app := newTestApplication(t)
+		app.config.accessTokens.format = "jwt"
+		app.config.accessTokens.ttl = 15 * time.Minute
+
+		var err error
+		app.config.accessTokens.keys, err = data.ParseAccessTokenKeys(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return app
+	}
+
+	const (
+		oldKey = "old=0123456789abcdef0123456789abcdef"
+		newKey = "new=fedcba9876543210fedcba9876543210"
+	)
+
+	login := func(t *testing.T, ts *testServer) sessionTokens {
+		t.Helper()
+
+		res := ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusCreated)
+
+		var tokens sessionTokens
+		decodeJSON(t, res, &tokens)
+
+		return tokens
+	}
+
+	t.Run("Permissions", func(t *testing.T) {
+		t.Parallel()
+
+		app := newSignedApplication(t, oldKey)
+		ts := newTestServer(t, app)
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+
+		tokens := login(t, ts)
+
+		if !data.IsSignedAccessToken(tokens.AuthenticationToken.Token) {
+			t.Fatalf("got authentication token %q; want a signed access token", tokens.AuthenticationToken.Token)
+		}
+		if tokens.AuthenticationToken.Expiry.After(time.Now().Add(15 * time.Minute)) {
+			t.Errorf("got expiry %v; want at most 15 minutes from now", tokens.AuthenticationToken.Expiry)
+		}
+
+		res := ts.request(t, http.MethodGet, "/v1/movies", tokens.AuthenticationToken.Token, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		res = ts.request(t, http.MethodPost, "/v1/movies", tokens.AuthenticationToken.Token, map[string]any{})
+		assertStatus(t, res, http.StatusForbidden)
+
+		// The token holds the permissions the user had when it was issued. New ones are
+		// picked up when it's refreshed.
+		err := app.models.Permissions.AddForUser(context.Background(), user.ID, "movies:write")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res = ts.request(t, http.MethodPost, "/v1/movies", tokens.AuthenticationToken.Token, map[string]any{})
+		assertStatus(t, res, http.StatusForbidden)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": tokens.RefreshToken.Token})
+		assertStatus(t, res, http.StatusCreated)
+
+		var refreshed sessionTokens
+		decodeJSON(t, res, &refreshed)
+
+		res = ts.request(t, http.MethodPost, "/v1/movies", refreshed.AuthenticationToken.Token, map[string]any{})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+
+		// Handlers which need the rest of the user's details look them up.
+		res = ts.request(t, http.MethodPost, "/v1/users/mfa/totp", refreshed.AuthenticationToken.Token, nil)
+		assertStatus(t, res, http.StatusCreated)
+
+		// Opaque tokens are still accepted.
+		res = ts.request(t, http.MethodGet, "/v1/movies", ts.newToken(t, user, data.ScopeAuthentication), nil)
+		assertStatus(t, res, http.StatusOK)
+	})
+
+	t.Run("Deleted user", func(t *testing.T) {
+		t.Parallel()
+
+		app := newSignedApplication(t, oldKey)
+		ts := newTestServer(t, app)
+		user := ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+
+		token := login(t, ts).AuthenticationToken.Token
+
+		err := app.models.Users.Delete(context.Background(), user.ID, user.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The token is still validly signed, but handlers which look the user up treat
+		// it as invalid.
+		tests := []struct {
+			method string
+			path   string
+			body   map[string]any
+		}{
+			{http.MethodGet, "/v1/users/me", nil},
+			{http.MethodPatch, "/v1/users/me", map[string]any{"version": 1}},
+			{http.MethodDelete, "/v1/users/me", map[string]any{"password": "pa55word1234"}},
+			{http.MethodPost, "/v1/users/mfa/totp", nil},
+			{http.MethodDelete, "/v1/users/mfa/totp", map[string]any{"code": "123456"}},
+		}
+
+		for _, tt := range tests {
+			res := ts.request(t, tt.method, tt.path, token, tt.body)
+			assertStatus(t, res, http.StatusUnauthorized)
+			assertJSON(t, res, `{"error": "invalid or missing authentication token"}`)
+		}
+	})
+
+	t.Run("Key rotation", func(t *testing.T) {
+		t.Parallel()
+
+		oldApp := newSignedApplication(t, oldKey)
+		oldTS := newTestServer(t, oldApp)
+		oldTS.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+
+		token := login(t, oldTS).AuthenticationToken.Token
+
+		// Servers with the new key in front still accept tokens signed with the old one...
+		rotatedApp := newSignedApplication(t, newKey+" "+oldKey)
+		rotatedApp.models = oldApp.models
+		rotatedTS := newTestServer(t, rotatedApp)
+
+		res := rotatedTS.request(t, http.MethodGet, "/v1/movies", token, nil)
+		assertStatus(t, res, http.StatusOK)
+
+		// ...but once the old key has been removed, they're rejected.
+		newApp := newSignedApplication(t, newKey)
+		newApp.models = oldApp.models
+		newTS := newTestServer(t, newApp)
+
+		res = newTS.request(t, http.MethodGet, "/v1/movies", token, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+		assertJSON(t, res, `{"error": "invalid or missing authentication token"}`)
+
+		// Signed access tokens aren't accepted at all when they're turned off.
+		oldApp.config.accessTokens.keys = nil
+
+		res = oldTS.request(t, http.MethodGet, "/v1/movies", token, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+	})
+}
+
+func TestDeleteAuthenticationToken(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	token := ts.newToken(t, user, data.ScopeAuthentication)
+	otherToken := ts.newToken(t, user, data.ScopeAuthentication)
+	refreshToken := ts.newToken(t, user, data.ScopeRefresh)
+
+	res := ts.request(t, http.MethodDelete, "/v1/tokens/authentication", "", nil)
+	assertStatus(t, res, http.StatusUnauthorized)
+
+	res = ts.request(t, http.MethodDelete, "/v1/tokens/authentication", token, map[string]any{"refresh_token": refreshToken})
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"message": "you have been logged out"}`)
+
+	// Only the current session is revoked.
+	res = ts.request(t, http.MethodGet, "/v1/movies", token, nil)
+	assertStatus(t, res, http.StatusUnauthorized)
+
+	res = ts.request(t, http.MethodGet, "/v1/movies", otherToken, nil)
+	assertStatus(t, res, http.StatusOK)
+
+	res = ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": refreshToken})
+	assertStatus(t, res, http.StatusUnprocessableEntity)
+}
+
+func TestDeleteAllTokens(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	alice := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	bob := ts.insertUser(t, "bob@example.com", "pa55word1234", true, "movies:read")
+
+	aliceTokens := []string{ts.newToken(t, alice, data.ScopeAuthentication), ts.newToken(t, alice, data.ScopeAuthentication)}
+	aliceRefresh := ts.newToken(t, alice, data.ScopeRefresh)
+	bobToken := ts.newToken(t, bob, data.ScopeAuthentication)
+
+	res := ts.request(t, http.MethodDelete, "/v1/tokens", aliceTokens[0], nil)
+	assertStatus(t, res, http.StatusOK)
+	assertJSON(t, res, `{"message": "you have been logged out of all sessions"}`)
+
+	for _, token := range aliceTokens {
+		res = ts.request(t, http.MethodGet, "/v1/movies", token, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+	}
+
+	res = ts.request(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]any{"refresh_token": aliceRefresh})
+	assertStatus(t, res, http.StatusUnprocessableEntity)
+
+	res = ts.request(t, http.MethodGet, "/v1/movies", bobToken, nil)
+	assertStatus(t, res, http.StatusOK)
+}
+
+func TestCreatePasswordResetToken(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+	ts.insertUser(t, "bob@example.com", "pa55word1234", false)
+
+	const message = `{"message": "if a matching account exists, an email will be sent to you containing password reset instructions"}`
+
+	// The response is the same whether or not an email is sent.
+	for _, email := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		res := ts.request(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]any{"email": email})
+
+		assertStatus(t, res, http.StatusAccepted)
+		assertJSON(t, res, message)
+	}
+
+	// Only the activated user should have been sent a reset token.
+	emails := sentEmails(app)
+	if len(emails) != 1 {
+		t.Fatalf("got %d emails; want 1", len(emails))
+	}
+	if emails[0].Recipient != "alice@example.com" || emails[0].Template != "token_password_reset.tmpl" {
+		t.Errorf("got email %+v; want password reset email to alice@example.com", emails[0])
+	}
+
+	token, _ := emailData(emails[0], "passwordResetToken").(string)
+	if _, err := app.models.Users.GetForToken(context.Background(), data.ScopePasswordReset, token); err != nil {
+		t.Errorf("password reset token in email is not valid: %v", err)
+	}
+
+	t.Run("Invalid email", func(t *testing.T) {
+		res := ts.request(t, http.MethodPost, "/v1/tokens/password-reset", "", map[string]any{"email": "alice"})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"email": "must be a valid email address"}}`)
+	})
+}
+
+func TestCreateActivationToken(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	ts.insertUser(t, "alice@example.com", "pa55word1234", true)
+	bob := ts.insertUser(t, "bob@example.com", "pa55word1234", false)
+	oldToken := ts.newToken(t, bob, data.ScopeActivation)
+
+	// Carol was deactivated by an admin, so she's not activated either.
+	carol := ts.insertUser(t, "carol@example.com", "pa55word1234", false)
+	deactivatedAt := time.Now()
+	carol.DeactivatedAt = &deactivatedAt
+
+	err := app.models.Users.Update(context.Background(), carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const message = `{"message": "if a matching account needs activating, an email will be sent to you containing activation instructions"}`
+
+	for _, email := range []string{"alice@example.com", "BOB@example.com", "carol@example.com", "dave@example.com"} {
+		res := ts.request(t, http.MethodPost, "/v1/tokens/activation", "", map[string]any{"email": email})
+
+		assertStatus(t, res, http.StatusAccepted)
+		assertJSON(t, res, message)
+	}
+
+	// Only the user who has never been activated should have been sent a new token.
+	emails := sentEmails(app)
+	if len(emails) != 1 {
+		t.Fatalf("got %d emails; want 1", len(emails))
+	}
+	if emails[0].Recipient != "bob@example.com" || emails[0].Template != "token_activation.tmpl" {
+		t.Errorf("got email %+v; want activation email to bob@example.com", emails[0])
+	}
+
+	// The old token no longer works, but the new one does.
+	res := ts.request(t, http.MethodPut, "/v1/users/activated", "", map[string]any{"token": oldToken})
+	assertStatus(t, res, http.StatusUnprocessableEntity)
+
+	res = ts.request(t, http.MethodPut, "/v1/users/activated", "", map[string]any{"token": emailData(emails[0], "activationToken")})
+	assertStatus(t, res, http.StatusOK)
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/rynhndrcksn/greenlight/internal/data"
+	"github.com/rynhndrcksn/greenlight/internal/mailer"
 	"github.com/rynhndrcksn/greenlight/internal/validator"
 )
 
@@ -16,6 +18,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Locale   string `json:"locale"`
 	}
 
 	// Parse the request body into the anonymous struct.
@@ -35,6 +38,13 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Activated: false,
 	}
 
+	// If the client didn't say which locale the user wants their emails in, pick the best
+	// match for the request's Accept-Language header.
+	user.PreferredLocale = input.Locale
+	if user.PreferredLocale == "" {
+		user.PreferredLocale = mailer.MatchLocale(app.readAcceptLanguage(r)...)
+	}
+
 	// Use the Password.Set() method to generate and store the hashed and plaintext passwords.
 	err = user.Password.Set(input.Password)
 	if err != nil {
@@ -46,13 +56,40 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// Validate the user struct and return the error messages to the client if any of
 	// the checks fail.
+	v.Check(slices.Contains(mailer.Locales(), user.PreferredLocale), "locale", "must be a supported locale")
+
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	// Insert the user, give them the viewer role (which grants the "movies:read"
+	// permission), and queue their welcome email in a single transaction. That way the
+	// email is only sent if the user is created, and it can't be lost if the server
+	// restarts before it's been sent.
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Roles.AddForUser(r.Context(), user.ID, data.RoleViewer)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(r.Context(), &data.Email{
+			Recipient: user.Email,
+			Locale:    user.PreferredLocale,
+			Template:  "user_welcome.tmpl",
+			Data:      map[string]any{"name": user.Name, "activationToken": token.Plaintext},
+		})
+	})
 	if err != nil {
 		switch {
 		// If we get an ErrDuplicateEmail error, use the v.AddError() method to manually
@@ -67,31 +104,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for all new users.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// After the user record has been created in the database, generate a new activation token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Use the background helper to execute an anonymous function that sends the welcome email.
-	app.background(func() {
-		d := map[string]any{
-			"activationToken": token.Plaintext,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", d)
-		if err != nil {
-			app.logger.Error(err.Error())
-		}
-	})
+	// Let the outbox worker know there's an email to send.
+	app.wakeOutbox()
 
 	// Write a JSON response containing the user data along with a 201 Created status code.
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
@@ -124,7 +138,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	// Retrieve the details of the user associated with the token using the
 	// GetForToken() method (which we will create in a minute). If no matching record
 	// is found, then we let the client know that the token they provided is not valid.
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -136,12 +150,19 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// An activation token left over from before an admin deactivated the user mustn't
+	// undo the deactivation.
+	if user.Deactivated() {
+		app.deactivatedAccountResponse(w, r)
+		return
+	}
+
 	// Update the user's activation status.
 	user.Activated = true
 
 	// Save the updated user record in our database, checking for any edit conflicts in
 	// the same way that we did for our movie records.
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -154,7 +175,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// If everything went successfully, then we delete all activation tokens for the
 	// user.
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -166,3 +187,89 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// updateUserPasswordHandler sets a new password for the user that a password reset
+// token belongs to. Once the password has been changed, all of the user's password
+// reset tokens and sessions are revoked.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's new password and password reset token.
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the details of the user associated with the password reset token,
+	// returning an error message if no matching record was found.
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Set the new password for the user.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Save the updated user record, which also increments the version number.
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete all password reset tokens for the user, and log them out everywhere in
+	// case someone else had access to the account.
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.revokeSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Nobody can keep guessing at the old password now, so lift any lockout.
+	err = app.resetLoginFailures(r.Context(), user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
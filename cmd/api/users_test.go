@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/data"
+)
+
+func TestRegisterUser(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	t.Run("Valid", func(t *testing.T) {
+		body := map[string]any{"name": "Alice Smith", "email": "alice@example.com", "password": "pa55word1234"}
+		res := ts.request(t, http.MethodPost, "/v1/users", "", body)
+
+		assertStatus(t, res, http.StatusAccepted)
+
+		var got struct {
+			User map[string]any `json:"user"`
+		}
+		decodeJSON(t, res, &got)
+
+		for key, want := range map[string]any{"id": 1.0, "name": "Alice Smith", "email": "alice@example.com", "activated": false} {
+			if got.User[key] != want {
+				t.Errorf("got user %s %v; want %v", key, got.User[key], want)
+			}
+		}
+		if _, ok := got.User["password"]; ok {
+			t.Error("response must not contain the password")
+		}
+
+		// The welcome email should contain an activation token for the new user.
+		emails := sentEmails(app)
+		if len(emails) != 1 {
+			t.Fatalf("got %d emails; want 1", len(emails))
+		}
+		if emails[0].Recipient != "alice@example.com" || emails[0].Template != "user_welcome.tmpl" {
+			t.Errorf("got email %+v; want welcome email to alice@example.com", emails[0])
+		}
+
+		token, _ := emailData(emails[0], "activationToken").(string)
+		if _, err := app.models.Users.GetForToken(context.Background(), data.ScopeActivation, token); err != nil {
+			t.Errorf("activation token in email is not valid: %v", err)
+		}
+
+		permissions, err := app.models.Permissions.GetAllForUser(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !permissions.Include("movies:read") || permissions.Include("movies:write") {
+			t.Errorf("got permissions %v; want [movies:read]", permissions)
+		}
+	})
+
+	t.Run("Duplicate email", func(t *testing.T) {
+		body := map[string]any{"name": "Alice Smith", "email": "ALICE@example.com", "password": "pa55word1234"}
+		res := ts.request(t, http.MethodPost, "/v1/users", "", body)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"email": "a user with this email address already exists"}}`)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		body := map[string]any{"name": "", "email": "not-an-email", "password": "short"}
+		res := ts.request(t, http.MethodPost, "/v1/users", "", body)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {
+			"name": "must be provided",
+			"email": "must be a valid email address",
+			"password": "must be at least 8 bytes long"
+		}}`)
+	})
+
+	t.Run("Locale", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			locale         string
+			acceptLanguage string
+			want           string
+		}{
+			{"Default", "", "", "en"},
+			{"From body", "es", "en-GB", "es"},
+			{"From Accept-Language", "", "fr-CA, es-MX;q=0.8, en;q=0.5", "es"},
+			{"Unsupported Accept-Language", "", "fr", "en"},
+		}
+
+		for i, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				email := fmt.Sprintf("locale%d@example.com", i)
+				body := fmt.Sprintf(`{"name": "Test User", "email": %q, "password": "pa55word1234", "locale": %q}`, email, tt.locale)
+
+				req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/users", strings.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.acceptLanguage != "" {
+					req.Header.Set("Accept-Language", tt.acceptLanguage)
+				}
+
+				res := ts.do(t, req)
+				assertStatus(t, res, http.StatusAccepted)
+
+				var got struct {
+					User map[string]any `json:"user"`
+				}
+				decodeJSON(t, res, &got)
+
+				if got.User["preferred_locale"] != tt.want {
+					t.Errorf("got preferred locale %v; want %q", got.User["preferred_locale"], tt.want)
+				}
+
+				emails := sentEmails(app)
+				last := emails[len(emails)-1]
+				if last.Recipient != email || last.Locale != tt.want {
+					t.Errorf("got email to %s in %q; want email to %s in %q", last.Recipient, last.Locale, email, tt.want)
+				}
+			})
+		}
+
+		res := ts.request(t, http.MethodPost, "/v1/users", "", map[string]any{"name": "Test User", "email": "fr@example.com", "password": "pa55word1234", "locale": "fr"})
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"locale": "must be a supported locale"}}`)
+	})
+}
+
+func TestActivateUser(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", false)
+	token := ts.newToken(t, user, data.ScopeActivation)
+
+	t.Run("Malformed token", func(t *testing.T) {
+		res := ts.request(t, http.MethodPut, "/v1/users/activated", "", map[string]any{"token": "abc"})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"token": "must be 26 bytes long"}}`)
+	})
+
+	t.Run("Valid token", func(t *testing.T) {
+		res := ts.request(t, http.MethodPut, "/v1/users/activated", "", map[string]any{"token": token})
+
+		assertStatus(t, res, http.StatusOK)
+
+		var got struct {
+			User struct {
+				ID        int64 `json:"id"`
+				Activated bool  `json:"activated"`
+			} `json:"user"`
+		}
+		decodeJSON(t, res, &got)
+
+		if got.User.ID != user.ID || !got.User.Activated {
+			t.Errorf("got user %+v; want activated user %d", got.User, user.ID)
+		}
+	})
+
+	t.Run("Used token", func(t *testing.T) {
+		res := ts.request(t, http.MethodPut, "/v1/users/activated", "", map[string]any{"token": token})
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"token": "invalid or expired activation token"}}`)
+	})
+}
+
+func TestUpdateUserPassword(t *testing.T) {
+	t.Parallel()
+
+	ts := newTestServer(t, newTestApplication(t))
+
+	user := ts.insertUser(t, "alice@example.com", "pa55word1234", true, "movies:read")
+	resetToken := ts.newToken(t, user, data.ScopePasswordReset)
+	otherResetToken := ts.newToken(t, user, data.ScopePasswordReset)
+	authToken := ts.newToken(t, user, data.ScopeAuthentication)
+
+	t.Run("Invalid token", func(t *testing.T) {
+		body := map[string]any{"password": "n3wpa55word", "token": authToken}
+		res := ts.request(t, http.MethodPut, "/v1/users/password", "", body)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"token": "invalid or expired password reset token"}}`)
+	})
+
+	t.Run("Invalid password", func(t *testing.T) {
+		body := map[string]any{"password": "short", "token": resetToken}
+		res := ts.request(t, http.MethodPut, "/v1/users/password", "", body)
+
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+		assertJSON(t, res, `{"error": {"password": "must be at least 8 bytes long"}}`)
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		body := map[string]any{"password": "n3wpa55word", "token": resetToken}
+		res := ts.request(t, http.MethodPut, "/v1/users/password", "", body)
+
+		assertStatus(t, res, http.StatusOK)
+		assertJSON(t, res, `{"message": "your password was successfully reset"}`)
+
+		stored, err := ts.app.models.Users.GetByEmail(context.Background(), "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Version != user.Version+1 {
+			t.Errorf("got version %d; want %d", stored.Version, user.Version+1)
+		}
+
+		// Existing sessions and the other reset token are revoked.
+		res = ts.request(t, http.MethodGet, "/v1/movies", authToken, nil)
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		body = map[string]any{"password": "an0therpa55word", "token": otherResetToken}
+		res = ts.request(t, http.MethodPut, "/v1/users/password", "", body)
+		assertStatus(t, res, http.StatusUnprocessableEntity)
+
+		// The user can log in with the new password, but not the old one.
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "pa55word1234"})
+		assertStatus(t, res, http.StatusUnauthorized)
+
+		res = ts.request(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]any{"email": "alice@example.com", "password": "n3wpa55word"})
+		assertStatus(t, res, http.StatusCreated)
+	})
+}
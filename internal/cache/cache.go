@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats holds the counters for a cache.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Size   int   `json:"size"`
+}
+
+// entry is a single value in the cache, along with the time it expires.
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// Cache is a size-bounded cache with a fixed time-to-live for each entry. Once the
+// cache is full, adding a new entry evicts the least recently used one. It's safe for
+// concurrent use.
+type Cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	items   map[K]*list.Element
+	recency *list.List // The front of the list is the most recently used entry.
+
+	// generation is incremented every time entries are deleted, so that GetOrLoad()
+	// can tell if a value it loaded might have been invalidated in the meantime.
+	generation uint64
+
+	hits   int64
+	misses int64
+
+	// now is used in place of time.Now() so the tests can control the clock.
+	now func() time.Time
+}
+
+// New returns a new Cache which holds up to size entries for the given ttl.
+func New[K comparable, V any](size int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		size:    size,
+		ttl:     ttl,
+		items:   make(map[K]*list.Element),
+		recency: list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value stored for the key, if there is an unexpired one.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(key)
+}
+
+// GetOrLoad returns the value stored for the key. If there isn't one, it calls load to
+// fetch the value and stores it for next time. Errors returned by load are never
+// cached.
+//
+// If any entries are deleted while load is running, the loaded value is still returned
+// but it isn't stored, as it might have been read before the change that caused the
+// deletion.
+func (c *Cache[K, V]) GetOrLoad(key K, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	value, ok := c.get(key)
+	generation := c.generation
+	c.mu.Unlock()
+
+	if ok {
+		return value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation == generation {
+		c.set(key, value)
+	}
+
+	return value, nil
+}
+
+// Set stores a value for the key, replacing any existing value.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value)
+}
+
+// Delete removes the value stored for the key, if there is one.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+}
+
+// DeleteFunc removes every entry for which del returns true.
+func (c *Cache[K, V]) DeleteFunc(del func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, element := range c.items {
+		e := element.Value.(*entry[K, V])
+		if del(e.key, e.value) {
+			c.remove(element)
+		}
+	}
+}
+
+// Stats returns the hit and miss counters, along with the current number of entries.
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{Hits: c.hits, Misses: c.misses, Size: len(c.items)}
+}
+
+// get looks up the key and updates the counters. The caller must hold the lock.
+func (c *Cache[K, V]) get(key K) (V, bool) {
+	element, ok := c.items[key]
+	if ok {
+		e := element.Value.(*entry[K, V])
+		if c.now().Before(e.expires) {
+			c.hits++
+			c.recency.MoveToFront(element)
+			return e.value, true
+		}
+
+		c.remove(element)
+	}
+
+	c.misses++
+
+	var zero V
+	return zero, false
+}
+
+// set stores a value, evicting the least recently used entry if the cache is full.
+// The caller must hold the lock.
+func (c *Cache[K, V]) set(key K, value V) {
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+
+	if c.size <= 0 {
+		return
+	}
+
+	for len(c.items) >= c.size {
+		c.remove(c.recency.Back())
+	}
+
+	e := &entry[K, V]{key: key, value: value, expires: c.now().Add(c.ttl)}
+	c.items[key] = c.recency.PushFront(e)
+}
+
+// remove deletes an entry. The caller must hold the lock.
+func (c *Cache[K, V]) remove(element *list.Element) {
+	c.recency.Remove(element)
+	delete(c.items, element.Value.(*entry[K, V]).key)
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Now()
+
+	c := New[string, int](10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("got %d, %t; want 1, true", v, ok)
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got an expired value")
+	}
+
+	want := Stats{Hits: 1, Misses: 1, Size: 0}
+	if got := c.Stats(); got != want {
+		t.Errorf("got stats %+v; want %+v", got, want)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := New[string, int](2, time.Minute)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a") // "b" is now the least recently used entry.
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("entry %q was evicted", key)
+		}
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	c := New[string, int](10, time.Minute)
+
+	calls := 0
+	load := func() (int, error) {
+		calls++
+		return 42, nil
+	}
+
+	for range 2 {
+		v, err := c.GetOrLoad("a", load)
+		if err != nil || v != 42 {
+			t.Fatalf("got %d, %v; want 42, nil", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d loads; want 1", calls)
+	}
+
+	t.Run("Errors are not cached", func(t *testing.T) {
+		errLoad := errors.New("load failed")
+
+		_, err := c.GetOrLoad("b", func() (int, error) { return 0, errLoad })
+		if err != errLoad {
+			t.Fatalf("got error %v; want %v", err, errLoad)
+		}
+		if _, ok := c.Get("b"); ok {
+			t.Error("failed load was cached")
+		}
+	})
+
+	t.Run("Invalidated while loading", func(t *testing.T) {
+		v, err := c.GetOrLoad("c", func() (int, error) {
+			c.DeleteFunc(func(string, int) bool { return false })
+			return 7, nil
+		})
+		if err != nil || v != 7 {
+			t.Fatalf("got %d, %v; want 7, nil", v, err)
+		}
+		if _, ok := c.Get("c"); ok {
+			t.Error("value loaded during an invalidation was cached")
+		}
+	})
+}
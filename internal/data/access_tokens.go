@@ -0,0 +1,198 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAccessToken is returned when a signed access token is malformed, has been
+// tampered with, was signed with a key we don't know, or has expired.
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// minAccessTokenKeyLength is the shortest key we accept for signing access tokens. It's
+// the length of the HMAC-SHA256 output, which is as much as the key can usefully be.
+const minAccessTokenKeyLength = 32
+
+// AccessTokenClaims are the details about a user carried in a signed access token, so
+// requests can be authenticated and authorized without looking the user up.
+type AccessTokenClaims struct {
+	UserID      int64
+	Activated   bool
+	Permissions Permissions
+	IssuedAt    time.Time
+	Expiry      time.Time
+}
+
+// accessTokenHeader is the JOSE header of a signed access token.
+type accessTokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// accessTokenPayload is the JWT claims set of a signed access token. The subject is the
+// user's ID, which JWTs always hold as a string.
+type accessTokenPayload struct {
+	Subject     string      `json:"sub"`
+	IssuedAt    int64       `json:"iat"`
+	Expiry      int64       `json:"exp"`
+	Activated   bool        `json:"activated"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// AccessTokenKeys holds the keys signed access tokens are signed and verified with. New
+// tokens are always signed with the current key, and the ID of the key is put in the
+// "kid" header so any of the keys can verify them. To rotate keys, add a new key in
+// front of the old one, and remove the old one once the tokens it signed have expired.
+type AccessTokenKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+// ParseAccessTokenKeys parses a space separated list of keys in the form "id=secret",
+// with the key used to sign new tokens first.
+func ParseAccessTokenKeys(s string) (*AccessTokenKeys, error) {
+	k := &AccessTokenKeys{keys: make(map[string][]byte)}
+
+	for _, field := range strings.Fields(s) {
+		id, secret, ok := strings.Cut(field, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("access token key %q must be in the form id=secret", field)
+		}
+		if len(secret) < minAccessTokenKeyLength {
+			return nil, fmt.Errorf("access token key %q must be at least %d bytes long", id, minAccessTokenKeyLength)
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("access token key %q is given more than once", id)
+		}
+
+		if k.current == "" {
+			k.current = id
+		}
+		k.keys[id] = []byte(secret)
+	}
+
+	if k.current == "" {
+		return nil, errors.New("no access token keys given")
+	}
+
+	return k, nil
+}
+
+// RandomAccessTokenKeys returns a single random key. It works fine for a single instance,
+// but the tokens it signs stop working when the server restarts and aren't accepted by
+// any other instances.
+func RandomAccessTokenKeys() (*AccessTokenKeys, error) {
+	secret := make([]byte, minAccessTokenKeyLength)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessTokenKeys{current: "random", keys: map[string][]byte{"random": secret}}, nil
+}
+
+// Sign returns a signed access token (a JWT signed with HMAC-SHA256) holding the claims,
+// in the same form as the opaque tokens we hand to clients.
+func (k *AccessTokenKeys) Sign(claims AccessTokenClaims) *Token {
+	// Marshalling structs of strings, ints and bools can never fail.
+	header, _ := json.Marshal(accessTokenHeader{Alg: "HS256", Typ: "JWT", Kid: k.current})
+	payload, _ := json.Marshal(accessTokenPayload{
+		Subject:     strconv.FormatInt(claims.UserID, 10),
+		IssuedAt:    claims.IssuedAt.Unix(),
+		Expiry:      claims.Expiry.Unix(),
+		Activated:   claims.Activated,
+		Permissions: claims.Permissions,
+	})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := signAccessToken(signingInput, k.keys[k.current])
+
+	return &Token{
+		Plaintext: signingInput + "." + base64.RawURLEncoding.EncodeToString(signature),
+		UserId:    claims.UserID,
+		Expiry:    claims.Expiry,
+		Scope:     ScopeAuthentication,
+	}
+}
+
+// Verify checks the signature and expiry of a signed access token at time t, and returns
+// the claims it holds, or ErrInvalidAccessToken if it isn't valid.
+func (k *AccessTokenKeys) Verify(token string, t time.Time) (*AccessTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidAccessToken
+	}
+
+	var header accessTokenHeader
+	if !decodeAccessTokenPart(parts[0], &header) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	// Only accept the algorithm we sign with, so a token can't pick a weaker one (or
+	// "none").
+	key, ok := k.keys[header.Kid]
+	if !ok || header.Alg != "HS256" {
+		return nil, ErrInvalidAccessToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, signAccessToken(parts[0]+"."+parts[1], key)) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	var payload accessTokenPayload
+	if !decodeAccessTokenPart(parts[1], &payload) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	userID, err := strconv.ParseInt(payload.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		return nil, ErrInvalidAccessToken
+	}
+
+	expiry := time.Unix(payload.Expiry, 0)
+	if !t.Before(expiry) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	return &AccessTokenClaims{
+		UserID:      userID,
+		Activated:   payload.Activated,
+		Permissions: payload.Permissions,
+		IssuedAt:    time.Unix(payload.IssuedAt, 0),
+		Expiry:      expiry,
+	}, nil
+}
+
+// IsSignedAccessToken reports whether a bearer token looks like a signed access token
+// rather than an opaque one. Opaque tokens are base32, so never contain a dot.
+func IsSignedAccessToken(token string) bool {
+	return strings.Contains(token, ".")
+}
+
+// decodeAccessTokenPart decodes a base64-encoded JSON part of a signed access token.
+func decodeAccessTokenPart(s string, dst any) bool {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(js, dst) == nil
+}
+
+// signAccessToken calculates the HMAC-SHA256 signature of a signed access token.
+func signAccessToken(signingInput string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessTokens(t *testing.T) {
+	const (
+		oldSecret = "0123456789abcdef0123456789abcdef"
+		newSecret = "fedcba9876543210fedcba9876543210"
+	)
+
+	oldKeys, err := ParseAccessTokenKeys("old=" + oldSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After rotation, new tokens are signed with the new key but the old key still
+	// verifies the tokens it signed.
+	rotatedKeys, err := ParseAccessTokenKeys("new=" + newSecret + " old=" + oldSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	claims := AccessTokenClaims{
+		UserID:      42,
+		Activated:   true,
+		Permissions: Permissions{"movies:read", "movies:write"},
+		IssuedAt:    now,
+		Expiry:      now.Add(15 * time.Minute),
+	}
+
+	oldToken := oldKeys.Sign(claims).Plaintext
+	newToken := rotatedKeys.Sign(claims).Plaintext
+
+	if !IsSignedAccessToken(oldToken) {
+		t.Errorf("IsSignedAccessToken(%q) = false; want true", oldToken)
+	}
+
+	for _, token := range []string{oldToken, newToken} {
+		got, err := rotatedKeys.Verify(token, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.UserID != 42 || !got.Activated || !slices.Equal(got.Permissions, claims.Permissions) || !got.Expiry.Equal(claims.Expiry) {
+			t.Errorf("got claims %+v; want %+v", got, claims)
+		}
+	}
+
+	// Once the old key has been removed, the tokens it signed are no longer accepted.
+	_, err = oldKeys.Verify(newToken, now)
+	if !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("got error %v for token signed with unknown key; want %v", err, ErrInvalidAccessToken)
+	}
+
+	header, payload, _ := strings.Cut(oldToken, ".")
+	payload, signature, _ := strings.Cut(payload, ".")
+
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		t     time.Time
+	}{
+		{"Expired", oldToken, claims.Expiry},
+		{"Malformed", "abc.def", now},
+		{"Tampered payload", header + "." + encode(`{"sub":"1","exp":9999999999,"permissions":["users:manage"]}`) + "." + signature, now},
+		{"Bad signature", header + "." + payload + "." + encode("signature"), now},
+		{"Unsigned", encode(`{"alg":"none","kid":"old"}`) + "." + payload + ".", now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := rotatedKeys.Verify(tt.token, tt.t)
+			if !errors.Is(err, ErrInvalidAccessToken) {
+				t.Errorf("got error %v; want %v", err, ErrInvalidAccessToken)
+			}
+		})
+	}
+}
+
+func TestParseAccessTokenKeys(t *testing.T) {
+	secret := strings.Repeat("x", 32)
+
+	for _, s := range []string{
+		"",
+		secret,
+		"=" + secret,
+		"a=short",
+		"a=" + secret + " a=" + secret,
+	} {
+		_, err := ParseAccessTokenKeys(s)
+		if err == nil {
+			t.Errorf("ParseAccessTokenKeys(%q) succeeded; want an error", s)
+		}
+	}
+}
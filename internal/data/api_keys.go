@@ -0,0 +1,260 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rynhndrcksn/greenlight/internal/validator"
+)
+
+// APIKeyPrefix starts every API key, so authenticate can tell them apart from tokens
+// (and so they're easy to spot if they're leaked).
+const APIKeyPrefix = "glk_"
+
+// ErrDuplicateAPIKey is returned when creating an API key with a name the user has
+// already given to another key.
+var ErrDuplicateAPIKey = errors.New("duplicate api key")
+
+// APIKey is a long-lived credential owned by a user, for services and batch jobs to call
+// the API with. It only grants the permissions listed on it, and only while its owner
+// still has them. The plaintext key is only set when the key has just been created.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+}
+
+// generate sets the plaintext of the key to a new random key, and the hash to its
+// SHA-256 hash. Like tokens, the keys are random enough that a fast hash is fine.
+func (k *APIKey) generate() error {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	k.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	k.Hash = hashAPIKey(k.Plaintext)
+	return nil
+}
+
+// hashAPIKey returns the SHA-256 hash of a plaintext API key.
+func hashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than a token.
+func IsAPIKey(s string) bool {
+	return strings.HasPrefix(s, APIKeyPrefix)
+}
+
+// ValidateAPIKey runs validation checks against a new API key. The allowed slice holds
+// the permission codes of the key's owner, which are the only ones the key can have.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, allowed Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range key.Permissions {
+		v.Check(allowed.Include(code), "permissions", "must only contain permission codes you have")
+	}
+}
+
+// APIKeyStore is the interface that wraps the methods for managing API keys.
+type APIKeyStore interface {
+	Insert(ctx context.Context, key *APIKey) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	GetForPlaintext(ctx context.Context, plaintext string) (*APIKey, error)
+	RecordUse(ctx context.Context, id int64) error
+	Delete(ctx context.Context, userID, id int64) error
+}
+
+// APIKeyModel struct wraps the connection pool.
+type APIKeyModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Insert generates a new random key and adds it along with its permissions, setting the
+// key's ID, creation time, plaintext and hash. It returns ErrDuplicateAPIKey if the user
+// already has a key with the same name.
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	err := key.generate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx DBTX) error {
+		query := `
+			INSERT INTO api_keys (user_id, name, hash)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at`
+
+		err := tx.QueryRowContext(ctx, query, key.UserID, key.Name, key.Hash).Scan(&key.ID, &key.CreatedAt)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "api_keys_user_id_name_key"`:
+				return ErrDuplicateAPIKey
+			default:
+				return err
+			}
+		}
+
+		query = `
+			INSERT INTO api_keys_permissions
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, key.ID, pq.StringArray(key.Permissions))
+		return err
+	})
+}
+
+// apiKeyColumns selects an API key along with its permissions. The FILTER clause stops
+// keys without any (known) permissions getting an array containing a single NULL.
+const apiKeyColumns = `
+	api_keys.id, api_keys.user_id, api_keys.name, api_keys.hash, api_keys.created_at, api_keys.last_used_at,
+	array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL)`
+
+// apiKeyJoins joins the permissions onto the api_keys table.
+const apiKeyJoins = `
+	LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+	LEFT JOIN permissions ON permissions.id = api_keys_permissions.permission_id`
+
+// scanAPIKey scans a row selected with apiKeyColumns.
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (*APIKey, error) {
+	var key APIKey
+
+	// pq.Array only recognises plain []string slices, so Permissions needs converting
+	// to scan into it.
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &key.CreatedAt, &key.LastUsedAt, (*pq.StringArray)(&key.Permissions))
+	if err != nil {
+		return nil, err
+	}
+
+	if key.Permissions == nil {
+		key.Permissions = Permissions{}
+	}
+
+	return &key, nil
+}
+
+// GetAllForUser returns all the user's API keys, oldest first.
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys` + apiKeyJoins + `
+		WHERE api_keys.user_id = $1
+		GROUP BY api_keys.id
+		ORDER BY api_keys.id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetForPlaintext returns the API key with the given plaintext, or ErrRecordNotFound if
+// there isn't one.
+func (m APIKeyModel) GetForPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys` + apiKeyJoins + `
+		WHERE api_keys.hash = $1
+		GROUP BY api_keys.id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hashAPIKey(plaintext)))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// RecordUse sets the time the API key was last used to now.
+func (m APIKeyModel) RecordUse(ctx context.Context, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Delete revokes one of the user's API keys, returning ErrRecordNotFound if the user
+// doesn't have a key with the ID.
+func (m APIKeyModel) Delete(ctx context.Context, userID, id int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
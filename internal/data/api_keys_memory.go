@@ -0,0 +1,143 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"slices"
+)
+
+// memoryAPIKeyStore is an in-memory implementation of APIKeyStore.
+type memoryAPIKeyStore struct {
+	db *memoryDB
+}
+
+// copyAPIKey returns a copy of a stored API key, without the plaintext.
+func copyAPIKey(key *APIKey) *APIKey {
+	c := *key
+	c.Permissions = slices.Clone(key.Permissions)
+	return &c
+}
+
+// Insert generates a new random key and adds it to the store, keeping only its known
+// permission codes like the INSERT ... SELECT query in the PostgreSQL implementation.
+func (s memoryAPIKeyStore) Insert(ctx context.Context, key *APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := key.generate()
+	if err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[key.UserID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	for _, existing := range s.db.apiKeys {
+		if existing.UserID == key.UserID && existing.Name == key.Name {
+			return ErrDuplicateAPIKey
+		}
+	}
+
+	s.db.lastAPIKeyID++
+	key.ID = s.db.lastAPIKeyID
+	key.CreatedAt = s.db.now()
+
+	stored := copyAPIKey(key)
+	stored.Plaintext = ""
+	stored.Permissions = Permissions{}
+	for _, code := range key.Permissions {
+		if s.db.permissions[code] {
+			stored.Permissions = append(stored.Permissions, code)
+		}
+	}
+	slices.Sort(stored.Permissions)
+
+	s.db.apiKeys[key.ID] = stored
+	return nil
+}
+
+// GetAllForUser returns all the user's API keys, oldest first.
+func (s memoryAPIKeyStore) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	keys := []*APIKey{}
+	for _, key := range s.db.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	slices.SortFunc(keys, func(a, b *APIKey) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return keys, nil
+}
+
+// GetForPlaintext returns the API key with the given plaintext.
+func (s memoryAPIKeyStore) GetForPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	hash := string(hashAPIKey(plaintext))
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, key := range s.db.apiKeys {
+		if string(key.Hash) == hash {
+			return copyAPIKey(key), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// RecordUse sets the time the API key was last used to now.
+func (s memoryAPIKeyStore) RecordUse(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	key, ok := s.db.apiKeys[id]
+	if !ok {
+		return nil
+	}
+
+	now := s.db.now()
+	updated := copyAPIKey(key)
+	updated.LastUsedAt = &now
+	s.db.apiKeys[id] = updated
+	return nil
+}
+
+// Delete revokes one of the user's API keys.
+func (s memoryAPIKeyStore) Delete(ctx context.Context, userID, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	key, ok := s.db.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(s.db.apiKeys, id)
+	return nil
+}
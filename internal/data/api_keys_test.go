@@ -0,0 +1,37 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestAPIKeyModelScan checks that the keys' permissions are scanned from the aggregated
+// text array, both when listing keys and when authenticating with one.
+func TestAPIKeyModelScan(t *testing.T) {
+	now := time.Now()
+	columns := []string{"id", "user_id", "name", "hash", "created_at", "last_used_at", "array_agg"}
+	row := []driver.Value{int64(1), int64(2), "nightly import", []byte("hash"), now, nil, []byte("{movies:read}")}
+
+	m := APIKeyModel{DB: newFakeDB(t, columns, row), QueryTimeout: time.Second}
+
+	keys, err := m.GetAllForUser(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !slices.Equal(keys[0].Permissions, Permissions{"movies:read"}) {
+		t.Fatalf("got keys %+v; want one key with movies:read", keys)
+	}
+
+	m = APIKeyModel{DB: newFakeDB(t, columns, row), QueryTimeout: time.Second}
+
+	key, err := m.GetForPlaintext(context.Background(), APIKeyPrefix+"secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(key.Permissions, Permissions{"movies:read"}) {
+		t.Errorf("got permissions %v; want movies:read", key.Permissions)
+	}
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"slices"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/cache"
+)
+
+// Caches holds the caches for the lookups which happen on every authenticated request:
+// the user for an authentication token, and the permissions for a user.
+//
+// Entries are invalidated whenever the store they're wrapping is written to through the
+// cached Models, but writes made by other instances of the application (or directly in
+// the database) are only picked up once the entries expire. Keep the TTL short.
+type Caches struct {
+	Users       *cache.Cache[[sha256.Size]byte, *User]
+	Permissions *cache.Cache[int64, Permissions]
+}
+
+// NewCaches returns caches which hold up to size entries each for the given ttl.
+func NewCaches(size int, ttl time.Duration) *Caches {
+	return &Caches{
+		Users:       cache.New[[sha256.Size]byte, *User](size, ttl),
+		Permissions: cache.New[int64, Permissions](size, ttl),
+	}
+}
+
+// Wrap returns a copy of the models which reads through, and invalidates, the caches.
+func (c *Caches) Wrap(models Models) Models {
+	return c.wrap(models, nil)
+}
+
+// wrap wraps the models, which are part of a transaction if pending isn't nil.
+func (c *Caches) wrap(models Models, pending *[]func(c *Caches)) Models {
+	inv := invalidator{caches: c, pending: pending}
+
+	models.Users = cachedUserStore{UserStore: models.Users, invalidator: inv}
+	models.Tokens = cachedTokenStore{TokenStore: models.Tokens, invalidator: inv}
+	models.Permissions = cachedPermissionStore{PermissionStore: models.Permissions, invalidator: inv}
+	models.Roles = cachedRoleStore{RoleStore: models.Roles, invalidator: inv}
+
+	transaction := models.transaction
+	models.transaction = func(ctx context.Context, fn func(tx Models) error) error {
+		// A transaction started inside another one is part of it, so its invalidations
+		// have to wait for the outer one to finish.
+		if pending != nil {
+			return transaction(ctx, func(tx Models) error {
+				return fn(c.wrap(tx, pending))
+			})
+		}
+
+		var txPending []func(c *Caches)
+		err := transaction(ctx, func(tx Models) error {
+			return fn(c.wrap(tx, &txPending))
+		})
+
+		for _, invalidate := range txPending {
+			invalidate(c)
+		}
+
+		return err
+	}
+
+	return models
+}
+
+// invalidator forgets cached entries when the stores are written to. Inside a
+// transaction, a request which reads between the write and the commit would cache the
+// old data again, so the entries are forgotten a second time once the transaction has
+// finished.
+type invalidator struct {
+	caches  *Caches
+	pending *[]func(c *Caches)
+}
+
+// invalidate calls fn to forget cached entries now, and again after the transaction
+// (if there is one) has finished.
+func (i invalidator) invalidate(fn func(c *Caches)) {
+	fn(i.caches)
+	if i.pending != nil {
+		*i.pending = append(*i.pending, fn)
+	}
+}
+
+// forgetUser removes every cached entry for a user.
+func (c *Caches) forgetUser(userID int64) {
+	c.Users.DeleteFunc(func(_ [sha256.Size]byte, user *User) bool {
+		return user.ID == userID
+	})
+	c.Permissions.Delete(userID)
+}
+
+// cachedUserStore caches the users for authentication tokens. Tokens with any other
+// scope are single-use, so there's no point caching them.
+type cachedUserStore struct {
+	UserStore
+	invalidator
+}
+
+// GetForToken retrieves the user that the provided token belongs to.
+func (s cachedUserStore) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	if tokenScope != ScopeAuthentication {
+		return s.UserStore.GetForToken(ctx, tokenScope, tokenPlaintext)
+	}
+
+	// Key the cache by the token hash, so we don't keep plaintext tokens in memory.
+	user, err := s.caches.Users.GetOrLoad(sha256.Sum256([]byte(tokenPlaintext)), func() (*User, error) {
+		return s.UserStore.GetForToken(ctx, tokenScope, tokenPlaintext)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Hand out a copy, so that callers can't change the cached user.
+	return copyUser(user), nil
+}
+
+// Update updates a user, forgetting any cached copies of them.
+func (s cachedUserStore) Update(ctx context.Context, user *User) error {
+	err := s.UserStore.Update(ctx, user)
+	s.invalidate(func(c *Caches) { c.forgetUser(user.ID) })
+	return err
+}
+
+// Delete deletes a user, forgetting any cached entries for them.
+func (s cachedUserStore) Delete(ctx context.Context, id int64, version int) error {
+	err := s.UserStore.Delete(ctx, id, version)
+	s.invalidate(func(c *Caches) { c.forgetUser(id) })
+	return err
+}
+
+// cachedTokenStore forgets cached users when their authentication tokens are deleted.
+type cachedTokenStore struct {
+	TokenStore
+	invalidator
+}
+
+// Delete deletes a single token.
+func (s cachedTokenStore) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	err := s.TokenStore.Delete(ctx, scope, tokenPlaintext)
+	if scope == ScopeAuthentication {
+		hash := sha256.Sum256([]byte(tokenPlaintext))
+		s.invalidate(func(c *Caches) { c.Users.Delete(hash) })
+	}
+	return err
+}
+
+// DeleteAllForUser deletes all tokens for a specific user and scope.
+func (s cachedTokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	err := s.TokenStore.DeleteAllForUser(ctx, scope, userID)
+	if scope == ScopeAuthentication {
+		s.invalidate(func(c *Caches) {
+			c.Users.DeleteFunc(func(_ [sha256.Size]byte, user *User) bool {
+				return user.ID == userID
+			})
+		})
+	}
+	return err
+}
+
+// cachedPermissionStore caches the effective permissions for each user.
+type cachedPermissionStore struct {
+	PermissionStore
+	invalidator
+}
+
+// GetAllForUser returns all the effective permission codes for a specific user.
+func (s cachedPermissionStore) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	permissions, err := s.caches.Permissions.GetOrLoad(userID, func() (Permissions, error) {
+		return s.PermissionStore.GetAllForUser(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Clone(permissions), nil
+}
+
+// AddForUser adds the provided permission code(s) for a specific user.
+func (s cachedPermissionStore) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	err := s.PermissionStore.AddForUser(ctx, userID, codes...)
+	s.invalidate(func(c *Caches) { c.Permissions.Delete(userID) })
+	return err
+}
+
+// RemoveForUser revokes a permission code which was granted directly to a specific user.
+func (s cachedPermissionStore) RemoveForUser(ctx context.Context, userID int64, code string) error {
+	err := s.PermissionStore.RemoveForUser(ctx, userID, code)
+	s.invalidate(func(c *Caches) { c.Permissions.Delete(userID) })
+	return err
+}
+
+// cachedRoleStore forgets a user's cached permissions when their roles change.
+type cachedRoleStore struct {
+	RoleStore
+	invalidator
+}
+
+// AddForUser assigns the named role(s) to a specific user.
+func (s cachedRoleStore) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	err := s.RoleStore.AddForUser(ctx, userID, names...)
+	s.invalidate(func(c *Caches) { c.Permissions.Delete(userID) })
+	return err
+}
+
+// RemoveForUser takes the named role away from a specific user.
+func (s cachedRoleStore) RemoveForUser(ctx context.Context, userID int64, name string) error {
+	err := s.RoleStore.RemoveForUser(ctx, userID, name)
+	s.invalidate(func(c *Caches) { c.Permissions.Delete(userID) })
+	return err
+}
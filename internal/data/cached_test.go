@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedModels(t *testing.T) {
+	ctx := context.Background()
+
+	caches := NewCaches(100, time.Minute)
+	models := caches.Wrap(NewMemoryModels())
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	user.Password.hash = []byte("hash")
+
+	err := models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := models.Tokens.New(ctx, user.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lookup fetches the user for the token and their permissions, like the
+	// authenticate and requirePermission middleware do.
+	lookup := func() (*User, Permissions) {
+		t.Helper()
+
+		user, err := models.Users.GetForToken(ctx, ScopeAuthentication, token.Plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		permissions, err := models.Permissions.GetAllForUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return user, permissions
+	}
+
+	lookup()
+	lookup()
+
+	if stats := caches.Users.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got user cache stats %+v; want 1 hit and 1 miss", stats)
+	}
+	if stats := caches.Permissions.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got permission cache stats %+v; want 1 hit and 1 miss", stats)
+	}
+
+	t.Run("Cached users are copies", func(t *testing.T) {
+		cached, _ := lookup()
+		cached.Name = "Mallory"
+
+		if cached, _ := lookup(); cached.Name != "Alice" {
+			t.Errorf("got name %q; want the cached user to be unchanged", cached.Name)
+		}
+	})
+
+	t.Run("Granting permissions", func(t *testing.T) {
+		err := models.Roles.AddForUser(ctx, user.ID, RoleEditor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, permissions := lookup(); !permissions.Include("movies:write") {
+			t.Errorf("got permissions %v after assigning a role; want movies:write", permissions)
+		}
+
+		err = models.Roles.RemoveForUser(ctx, user.ID, RoleEditor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, permissions := lookup(); permissions.Include("movies:write") {
+			t.Errorf("got permissions %v after removing a role; want no movies:write", permissions)
+		}
+
+		err = models.Permissions.AddForUser(ctx, user.ID, "movies:write")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, permissions := lookup(); !permissions.Include("movies:write") {
+			t.Errorf("got permissions %v after a direct grant; want movies:write", permissions)
+		}
+	})
+
+	t.Run("Writes in a transaction", func(t *testing.T) {
+		err := models.Transaction(ctx, func(tx Models) error {
+			err := tx.Permissions.RemoveForUser(ctx, user.ID, "movies:write")
+			if err != nil {
+				return err
+			}
+
+			// Simulate another request reading the permissions before the transaction
+			// commits, and caching them as they were.
+			caches.Permissions.Set(user.ID, Permissions{"movies:read", "movies:write"})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, permissions := lookup(); permissions.Include("movies:write") {
+			t.Errorf("got permissions %v after the transaction; want no movies:write", permissions)
+		}
+	})
+
+	t.Run("Updating the user", func(t *testing.T) {
+		stored, err := models.Users.Get(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stored.Activated = false
+		err = models.Users.Update(ctx, stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cached, _ := lookup(); cached.Activated {
+			t.Error("got a stale activated user from the cache")
+		}
+	})
+
+	t.Run("Deleting the token", func(t *testing.T) {
+		err := models.Tokens.DeleteAllForUser(ctx, ScopeAuthentication, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = models.Users.GetForToken(ctx, ScopeAuthentication, token.Plaintext)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got error %v for a deleted token; want %v", err, ErrRecordNotFound)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailChangeStore is the interface that wraps the methods for storing the new email
+// addresses users have asked to change to. A change only takes effect once the user
+// confirms it with the email-change token sent to the new address, so each user has at
+// most one pending change.
+type EmailChangeStore interface {
+	Set(ctx context.Context, userID int64, email string) error
+	Get(ctx context.Context, userID int64) (string, error)
+	Delete(ctx context.Context, userID int64) error
+}
+
+// EmailChangeModel struct wraps the connection pool.
+type EmailChangeModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Set records the email address the user wants to change to, replacing any earlier
+// change which hasn't been confirmed.
+func (m EmailChangeModel) Set(ctx context.Context, userID int64, email string) error {
+	query := `
+		INSERT INTO email_changes (user_id, email)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, email)
+	return err
+}
+
+// Get returns the email address the user wants to change to, or ErrRecordNotFound if
+// they don't have a pending change.
+func (m EmailChangeModel) Get(ctx context.Context, userID int64) (string, error) {
+	query := `
+		SELECT email
+		FROM email_changes
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var email string
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
+
+// Delete removes the user's pending change, if they have one.
+func (m EmailChangeModel) Delete(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM email_changes
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
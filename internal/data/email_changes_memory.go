@@ -0,0 +1,56 @@
+package data
+
+import "context"
+
+// memoryEmailChangeStore is an in-memory implementation of EmailChangeStore.
+type memoryEmailChangeStore struct {
+	db *memoryDB
+}
+
+// Set records the email address the user wants to change to, replacing any earlier
+// change which hasn't been confirmed.
+func (s memoryEmailChangeStore) Set(ctx context.Context, userID int64, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	s.db.emailChanges[userID] = email
+	return nil
+}
+
+// Get returns the email address the user wants to change to.
+func (s memoryEmailChangeStore) Get(ctx context.Context, userID int64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	email, ok := s.db.emailChanges[userID]
+	if !ok {
+		return "", ErrRecordNotFound
+	}
+
+	return email, nil
+}
+
+// Delete removes the user's pending change, if they have one.
+func (s memoryEmailChangeStore) Delete(ctx context.Context, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.emailChanges, userID)
+	return nil
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+)
+
+// newFakeDB returns a database whose queries all return the given columns and rows, so
+// the PostgreSQL models' scanning can be tested without a database server. The values
+// should be what lib/pq would return, like []byte for a text array.
+func newFakeDB(t *testing.T, columns []string, rows ...[]driver.Value) *sql.DB {
+	t.Helper()
+
+	db := sql.OpenDB(fakeConnector{columns: columns, rows: rows})
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+type fakeConnector struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn fakeConnector
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{columns: c.columns, rows: c.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -1,26 +1,49 @@
 package data
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/rynhndrcksn/greenlight/internal/validator"
 )
 
 // Filters struct contains information for filtering the results from the database.
+// If Cursor is nil the results are paginated with Page and PageSize, otherwise we use
+// keyset pagination starting from the position of the cursor.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       *Cursor
 }
 
 // Metadata struct contains metadata information regarding the query.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+
+	// next and prev hold the unsigned cursors for the neighbouring pages when keyset
+	// pagination is used. They're turned into NextCursor and PrevCursor by SignCursors().
+	next *Cursor
+	prev *Cursor
+}
+
+// SignCursors encodes and signs the cursors for the neighbouring pages (if any) using
+// the provided key.
+func (m *Metadata) SignCursors(key []byte) {
+	if m.next != nil {
+		m.NextCursor = EncodeCursor(*m.next, key)
+	}
+	if m.prev != nil {
+		m.PrevCursor = EncodeCursor(*m.prev, key)
+	}
 }
 
 // ValidateFilters validates all the filters being passed to the API.
@@ -33,6 +56,17 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort parameter matches a value in the safe list.
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	// A cursor is only meaningful for the sort it was created with, and (apart from
+	// when sorting by title) the sort value it holds must be an integer.
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "must be used with the sort value it was created for")
+
+		if f.Cursor.ID != 0 && strings.TrimPrefix(f.Cursor.Sort, "-") != "title" {
+			_, err := strconv.ParseInt(f.Cursor.Value, 10, 64)
+			v.Check(err == nil, "cursor", "invalid cursor")
+		}
+	}
 }
 
 // sortColumn determines which column, if any, we're sorting by.
@@ -69,6 +103,110 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// keyset returns the extra WHERE condition and the ORDER BY clause for keyset
+// pagination from the cursor position, using the given placeholder numbers for the
+// cursor's sort value and ID.
+//
+// Rows are normally ordered by the sort column followed by "id ASC", so for a forward
+// cursor we want the rows which come after (value, id) in that order. For a backward
+// cursor we flip every comparison and the order itself to get the rows immediately
+// before (value, id); the caller needs to reverse them again afterwards. If the cursor
+// is at the start of the list the condition is simply TRUE.
+func (f Filters) keyset(valueParam, idParam int) (condition, orderBy string) {
+	column := f.sortColumn()
+	descending := f.sortDirection() == "DESC"
+
+	// When moving backwards, the rows we want sort *before* the cursor.
+	columnOp, idOp := ">", ">"
+	if descending {
+		columnOp = "<"
+	}
+	if f.Cursor.Backward {
+		columnOp, idOp = flipComparison(columnOp), flipComparison(idOp)
+	}
+
+	condition = "TRUE"
+	if f.Cursor.ID != 0 {
+		condition = fmt.Sprintf("(%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND id %[3]s $%[5]d))",
+			column, columnOp, idOp, valueParam, idParam)
+	}
+
+	direction, idDirection := f.sortDirection(), "ASC"
+	if f.Cursor.Backward {
+		direction, idDirection = flipDirection(direction), flipDirection(idDirection)
+	}
+	orderBy = fmt.Sprintf("%s %s, id %s", column, direction, idDirection)
+
+	return condition, orderBy
+}
+
+// cursorValue returns the cursor's sort value as the type of the sort column.
+// ValidateFilters() has already checked that the value can be parsed.
+func (f Filters) cursorValue() any {
+	if f.sortColumn() == "title" {
+		return f.Cursor.Value
+	}
+
+	i, _ := strconv.ParseInt(f.Cursor.Value, 10, 64)
+	return i
+}
+
+// flipComparison returns the opposite of a strict comparison operator.
+func flipComparison(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+// flipDirection returns the opposite of a sort direction.
+func flipDirection(direction string) string {
+	if direction == "ASC" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// calculateCursorMetadata calculates the pagination metadata for a page of movies
+// fetched with keyset pagination. The movies must already be in their final order,
+// and hasMore reports whether there are more rows beyond the page in the direction
+// the cursor was moving.
+//
+// A page can come back empty when the rows it pointed at have since been deleted. If
+// the cursor wasn't at the start of the list, there are no rows left in the direction
+// it was moving, so the client gets a cursor for the other end of the list to page
+// back from (a zero ID with Backward set starts from the end).
+func calculateCursorMetadata(movies []*Movie, hasMore bool, filters Filters) Metadata {
+	if len(movies) == 0 {
+		if filters.Cursor.ID == 0 {
+			return Metadata{}
+		}
+
+		metadata := Metadata{PageSize: filters.PageSize}
+		if filters.Cursor.Backward {
+			metadata.next = &Cursor{Sort: filters.Sort}
+		} else {
+			metadata.prev = &Cursor{Sort: filters.Sort, Backward: true}
+		}
+		return metadata
+	}
+
+	column := filters.sortColumn()
+	first, last := movies[0], movies[len(movies)-1]
+	metadata := Metadata{PageSize: filters.PageSize}
+
+	// Moving forwards, there's a next page if we found more rows, and a previous page
+	// unless we started from the beginning. Moving backwards it's the other way around.
+	if (!filters.Cursor.Backward && hasMore) || filters.Cursor.Backward {
+		metadata.next = &Cursor{Sort: filters.Sort, Value: movieSortValue(last, column), ID: last.ID}
+	}
+	if (filters.Cursor.Backward && hasMore) || (!filters.Cursor.Backward && filters.Cursor.ID != 0) {
+		metadata.prev = &Cursor{Sort: filters.Sort, Value: movieSortValue(first, column), ID: first.ID, Backward: true}
+	}
+
+	return metadata
+}
+
 // calculateMetadata calculates the appropriate pagination metadata
 // values given the total number of records, current page, and page size values.
 // Note: that when the last page value is calculated, we are dividing two int values, and
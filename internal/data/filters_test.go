@@ -0,0 +1,140 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestFiltersKeyset(t *testing.T) {
+	tests := []struct {
+		name          string
+		sort          string
+		cursor        Cursor
+		wantCondition string
+		wantOrderBy   string
+	}{
+		{
+			name:          "First page",
+			sort:          "year",
+			cursor:        Cursor{Sort: "year"},
+			wantCondition: "TRUE",
+			wantOrderBy:   "year ASC, id ASC",
+		},
+		{
+			name:          "Forward ascending",
+			sort:          "year",
+			cursor:        Cursor{Sort: "year", Value: "2016", ID: 3},
+			wantCondition: "(year > $3 OR (year = $3 AND id > $4))",
+			wantOrderBy:   "year ASC, id ASC",
+		},
+		{
+			name:          "Forward descending",
+			sort:          "-year",
+			cursor:        Cursor{Sort: "-year", Value: "2016", ID: 3},
+			wantCondition: "(year < $3 OR (year = $3 AND id > $4))",
+			wantOrderBy:   "year DESC, id ASC",
+		},
+		{
+			name:          "Backward ascending",
+			sort:          "title",
+			cursor:        Cursor{Sort: "title", Value: "Moana", ID: 1, Backward: true},
+			wantCondition: "(title < $3 OR (title = $3 AND id < $4))",
+			wantOrderBy:   "title DESC, id DESC",
+		},
+		{
+			name:          "Backward descending",
+			sort:          "-runtime",
+			cursor:        Cursor{Sort: "-runtime", Value: "107", ID: 1, Backward: true},
+			wantCondition: "(runtime > $3 OR (runtime = $3 AND id < $4))",
+			wantOrderBy:   "runtime ASC, id DESC",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{
+				PageSize:     20,
+				Sort:         tt.sort,
+				SortSafeList: []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"},
+				Cursor:       &tt.cursor,
+			}
+
+			condition, orderBy := f.keyset(3, 4)
+
+			if condition != tt.wantCondition {
+				t.Errorf("got condition %q; want %q", condition, tt.wantCondition)
+			}
+			if orderBy != tt.wantOrderBy {
+				t.Errorf("got order by %q; want %q", orderBy, tt.wantOrderBy)
+			}
+		})
+	}
+}
+
+func TestCursorEncoding(t *testing.T) {
+	key := []byte("secret")
+	cursor := Cursor{Sort: "-title", Value: "Black Panther", ID: 2, Backward: true}
+
+	encoded := EncodeCursor(cursor, key)
+
+	decoded, err := DecodeCursor(encoded, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != cursor {
+		t.Errorf("got %+v; want %+v", decoded, cursor)
+	}
+
+	_, err = DecodeCursor(encoded, []byte("another secret"))
+	if err != ErrInvalidCursor {
+		t.Errorf("got error %v for the wrong key; want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestCalculateCursorMetadataEmptyPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		cursor   Cursor
+		wantNext *Cursor
+		wantPrev *Cursor
+	}{
+		{
+			name:     "Empty list",
+			cursor:   Cursor{Sort: "year"},
+			wantNext: nil,
+			wantPrev: nil,
+		},
+		{
+			name:     "Empty backward page",
+			cursor:   Cursor{Sort: "year", Value: "2018", ID: 3, Backward: true},
+			wantNext: &Cursor{Sort: "year"},
+			wantPrev: nil,
+		},
+		{
+			name:     "Empty forward page",
+			cursor:   Cursor{Sort: "year", Value: "2018", ID: 3},
+			wantNext: nil,
+			wantPrev: &Cursor{Sort: "year", Backward: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{PageSize: 2, Sort: tt.cursor.Sort, SortSafeList: []string{"year"}, Cursor: &tt.cursor}
+			metadata := calculateCursorMetadata(nil, false, f)
+
+			if !equalCursors(metadata.next, tt.wantNext) {
+				t.Errorf("got next cursor %+v; want %+v", metadata.next, tt.wantNext)
+			}
+			if !equalCursors(metadata.prev, tt.wantPrev) {
+				t.Errorf("got prev cursor %+v; want %+v", metadata.prev, tt.wantPrev)
+			}
+		})
+	}
+}
+
+func equalCursors(a, b *Cursor) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
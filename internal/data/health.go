@@ -0,0 +1,71 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MigrationVersion is the version of the newest migration in the migrations directory,
+// which is the schema version this build of the application expects. It must be bumped
+// whenever a migration is added.
+const MigrationVersion = 20
+
+// HealthStore reports on the health of the storage backend, for the readiness probe.
+type HealthStore interface {
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+	// SchemaVersion returns the version of the last migration applied to the database,
+	// and whether that migration failed part way through (leaving the schema "dirty").
+	SchemaVersion(ctx context.Context) (version int64, dirty bool, err error)
+}
+
+// HealthModel is the PostgreSQL implementation of HealthStore.
+type HealthModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Ping checks that a connection to the database can be made, using the connection pool's
+// PingContext() if there is one, or a trivial query otherwise.
+func (m HealthModel) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	if pool, ok := m.DB.(*sql.DB); ok {
+		return pool.PingContext(ctx)
+	}
+
+	var one int
+	return m.DB.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// SchemaVersion reads the current version from the schema_migrations table kept by the
+// migrate tool. If no migrations have been applied, the version is 0.
+func (m HealthModel) SchemaVersion(ctx context.Context) (int64, bool, error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var (
+		version int64
+		dirty   bool
+	)
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
@@ -0,0 +1,35 @@
+package data
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMigrationVersion checks that MigrationVersion was bumped along with the newest
+// migration, as otherwise the readiness probe would reject (or accept) the wrong schema.
+func TestMigrationVersion(t *testing.T) {
+	entries, err := os.ReadDir("../../migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newest int64
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			continue
+		}
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			t.Fatalf("migration %q: %v", entry.Name(), err)
+		}
+		newest = max(newest, version)
+	}
+
+	if newest != MigrationVersion {
+		t.Errorf("got MigrationVersion %d; want %d (the newest migration)", MigrationVersion, newest)
+	}
+}
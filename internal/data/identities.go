@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateIdentity is returned when linking an external identity which is already
+// linked to a user.
+var ErrDuplicateIdentity = errors.New("duplicate identity")
+
+// Identity links a user to their account with an external OpenID Connect provider. The
+// issuer and subject together identify the account; the email is the one the provider
+// gave when the identity was linked.
+type Identity struct {
+	Issuer    string
+	Subject   string
+	UserID    int64
+	Email     string
+	CreatedAt time.Time
+}
+
+// IdentityStore is the interface that wraps the methods for linking external identities
+// to users. Users are looked up by their identities with UserStore.GetForIdentity.
+type IdentityStore interface {
+	Insert(ctx context.Context, identity *Identity) error
+}
+
+// IdentityModel struct wraps the connection pool.
+type IdentityModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Insert links an external identity to a user, returning ErrDuplicateIdentity if it's
+// already linked to one.
+func (m IdentityModel) Insert(ctx context.Context, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`
+
+	args := []any{identity.Issuer, identity.Subject, identity.UserID, identity.Email}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_pkey"`:
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OIDCLogin is a login with an OpenID Connect provider which has been started but not
+// finished. It's looked up by the state the provider sends back with the authorization
+// code, and holds the nonce and PKCE code verifier needed to finish the login.
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+// OIDCLoginStore is the interface that wraps the methods for storing unfinished logins
+// with OpenID Connect providers.
+type OIDCLoginStore interface {
+	Insert(ctx context.Context, login *OIDCLogin) error
+	Take(ctx context.Context, state string) (*OIDCLogin, error)
+}
+
+// OIDCLoginModel struct wraps the connection pool.
+type OIDCLoginModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Insert stores a new login, and clears out any logins which expired without being
+// finished. Only the hash of the state is stored.
+func (m OIDCLoginModel) Insert(ctx context.Context, login *OIDCLogin) error {
+	stateHash := sha256.Sum256([]byte(login.State))
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry <= NOW()`)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err = m.DB.ExecContext(ctx, query, stateHash[:], login.Nonce, login.CodeVerifier, login.Expiry)
+	return err
+}
+
+// Take deletes and returns the unexpired login with the given state, or returns
+// ErrRecordNotFound if there isn't one. Each login can only be taken once, so a state
+// can't be replayed.
+func (m OIDCLoginModel) Take(ctx context.Context, state string) (*OIDCLogin, error) {
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND expiry > $2
+		RETURNING nonce, code_verifier, expiry`
+
+	login := OIDCLogin{State: state}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, stateHash[:], time.Now()).Scan(&login.Nonce, &login.CodeVerifier, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &login, nil
+}
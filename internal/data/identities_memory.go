@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"maps"
+	"time"
+)
+
+// identityKey returns the key for an identity in the in-memory store.
+func identityKey(issuer, subject string) string {
+	return issuer + "\x00" + subject
+}
+
+// memoryIdentityStore is an in-memory implementation of IdentityStore.
+type memoryIdentityStore struct {
+	db *memoryDB
+}
+
+// Insert links an external identity to a user, returning ErrDuplicateIdentity if it's
+// already linked to one.
+func (s memoryIdentityStore) Insert(ctx context.Context, identity *Identity) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[identity.UserID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	key := identityKey(identity.Issuer, identity.Subject)
+	if _, ok := s.db.identities[key]; ok {
+		return ErrDuplicateIdentity
+	}
+
+	identity.CreatedAt = s.db.now()
+
+	stored := *identity
+	s.db.identities[key] = &stored
+	return nil
+}
+
+// memoryOIDCLoginStore is an in-memory implementation of OIDCLoginStore.
+type memoryOIDCLoginStore struct {
+	db *memoryDB
+}
+
+// Insert stores a new login, and clears out any logins which expired without being
+// finished.
+func (s memoryOIDCLoginStore) Insert(ctx context.Context, login *OIDCLogin) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stateHash := sha256.Sum256([]byte(login.State))
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	maps.DeleteFunc(s.db.oidcLogins, func(_ string, login *OIDCLogin) bool {
+		return !login.Expiry.After(now)
+	})
+
+	// Only the hash of the state is persisted in the database, so don't keep it.
+	stored := *login
+	stored.State = ""
+	stored.Expiry = login.Expiry.Truncate(time.Second)
+	s.db.oidcLogins[string(stateHash[:])] = &stored
+	return nil
+}
+
+// Take deletes and returns the unexpired login with the given state.
+func (s memoryOIDCLoginStore) Take(ctx context.Context, state string) (*OIDCLogin, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stateHash := sha256.Sum256([]byte(state))
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.oidcLogins[string(stateHash[:])]
+	if !ok || !stored.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	delete(s.db.oidcLogins, string(stateHash[:]))
+
+	login := *stored
+	login.State = state
+	return &login, nil
+}
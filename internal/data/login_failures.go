@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginFailures holds the recent failed login attempts for a key, which identifies
+// either an account (by email address) or a client IP address, and whether further
+// attempts are locked out.
+type LoginFailures struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Locked reports whether login attempts for the key are locked out at the given time.
+func (f *LoginFailures) Locked(now time.Time) bool {
+	return f.LockedUntil != nil && now.Before(*f.LockedUntil)
+}
+
+// LoginFailureStore is the interface that wraps the methods for tracking failed login
+// attempts.
+type LoginFailureStore interface {
+	Get(ctx context.Context, key string) (*LoginFailures, error)
+	RecordFailure(ctx context.Context, key string, window time.Duration) (*LoginFailures, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// LoginFailureModel struct wraps the connection pool.
+type LoginFailureModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Get returns the failed login attempts for the key. If there have been none, it returns
+// a LoginFailures with no failures rather than an error.
+func (m LoginFailureModel) Get(ctx context.Context, key string) (*LoginFailures, error) {
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_failures
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var f LoginFailures
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&f.Key, &f.Failures, &f.LastFailureAt, &f.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &LoginFailures{Key: key}, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &f, nil
+}
+
+// RecordFailure counts a failed login attempt for the key, and returns the updated
+// record. Failures are only counted together if each one was within window of the one
+// before, so the count starts again from one after a quiet period. The count is
+// updated in a single statement, so concurrent failures are all counted.
+func (m LoginFailureModel) RecordFailure(ctx context.Context, key string, window time.Duration) (*LoginFailures, error) {
+	query := `
+		INSERT INTO login_failures (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE WHEN login_failures.last_failure_at < $2 THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure_at = NOW()
+		RETURNING key, failures, last_failure_at, locked_until`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var f LoginFailures
+
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now().Add(-window)).Scan(&f.Key, &f.Failures, &f.LastFailureAt, &f.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Lock locks out login attempts for the key until the given time.
+func (m LoginFailureModel) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET locked_until = $2
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, until)
+	return err
+}
+
+// Reset forgets the failed login attempts for the key, lifting any lockout.
+func (m LoginFailureModel) Reset(ctx context.Context, key string) error {
+	query := `
+		DELETE FROM login_failures
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// Prune deletes the failed login attempts for every key whose last failure was before
+// the given time and which isn't locked out, returning how many were deleted. Keys are
+// chosen by whoever is logging in, so without this the table would grow forever.
+func (m LoginFailureModel) Prune(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM login_failures
+		WHERE last_failure_at < $1
+		AND (locked_until IS NULL OR locked_until < NOW())`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
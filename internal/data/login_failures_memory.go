@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// memoryLoginFailureStore is an in-memory implementation of LoginFailureStore.
+type memoryLoginFailureStore struct {
+	db *memoryDB
+}
+
+// Get returns a copy of the failed login attempts for the key.
+func (s memoryLoginFailureStore) Get(ctx context.Context, key string) (*LoginFailures, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	f, ok := s.db.loginFailures[key]
+	if !ok {
+		return &LoginFailures{Key: key}, nil
+	}
+
+	fCopy := *f
+	return &fCopy, nil
+}
+
+// RecordFailure counts a failed login attempt for the key.
+func (s memoryLoginFailureStore) RecordFailure(ctx context.Context, key string, window time.Duration) (*LoginFailures, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := s.db.now()
+
+	// Records are replaced rather than modified in place, so that rolling back a
+	// transaction only needs a copy of the map.
+	f := LoginFailures{Key: key}
+	if existing, ok := s.db.loginFailures[key]; ok {
+		f = *existing
+	}
+
+	if f.LastFailureAt.Before(now.Add(-window)) {
+		f.Failures = 0
+	}
+	f.Failures++
+	f.LastFailureAt = now
+
+	s.db.loginFailures[key] = &f
+
+	fCopy := f
+	return &fCopy, nil
+}
+
+// Lock locks out login attempts for the key until the given time.
+func (s memoryLoginFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	existing, ok := s.db.loginFailures[key]
+	if !ok {
+		return nil
+	}
+
+	f := *existing
+	f.LockedUntil = &until
+	s.db.loginFailures[key] = &f
+
+	return nil
+}
+
+// Reset forgets the failed login attempts for the key.
+func (s memoryLoginFailureStore) Reset(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.loginFailures, key)
+	return nil
+}
+
+// Prune deletes the failed login attempts for every key whose last failure was before
+// the given time and which isn't locked out.
+func (s memoryLoginFailureStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := s.db.now()
+
+	var deleted int64
+	for key, f := range s.db.loginFailures {
+		if f.LastFailureAt.Before(before) && !f.Locked(now) {
+			delete(s.db.loginFailures, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoginFailures(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	f, err := models.LoginFailures.Get(ctx, "email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Failures != 0 || f.Locked(time.Now()) {
+		t.Errorf("got %+v; want no failures", f)
+	}
+
+	for i := range 3 {
+		f, err = models.LoginFailures.RecordFailure(ctx, "email:alice@example.com", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Failures != i+1 {
+			t.Errorf("got %d failures; want %d", f.Failures, i+1)
+		}
+	}
+
+	// Failures outside the window aren't counted.
+	f, err = models.LoginFailures.RecordFailure(ctx, "email:alice@example.com", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Failures != 1 {
+		t.Errorf("got %d failures after the window; want 1", f.Failures)
+	}
+
+	until := time.Now().Add(time.Hour)
+	err = models.LoginFailures.Lock(ctx, "email:alice@example.com", until)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = models.LoginFailures.Get(ctx, "email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Locked(time.Now()) || f.Locked(until.Add(time.Second)) {
+		t.Errorf("got locked until %v; want locked until %v", f.LockedUntil, until)
+	}
+
+	err = models.LoginFailures.Reset(ctx, "email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = models.LoginFailures.Get(ctx, "email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Failures != 0 || f.Locked(time.Now()) {
+		t.Errorf("got %+v after reset; want no failures", f)
+	}
+}
+
+func TestLoginFailuresPrune(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	for _, key := range []string{"email:alice@example.com", "ip:192.0.2.1", "ip:192.0.2.2"} {
+		_, err := models.LoginFailures.RecordFailure(ctx, key, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := models.LoginFailures.Lock(ctx, "ip:192.0.2.2", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing has failed before the cutoff yet.
+	deleted, err := models.LoginFailures.Prune(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Errorf("got %d deleted; want 0", deleted)
+	}
+
+	// Old failures are deleted, unless the key is still locked out.
+	deleted, err = models.LoginFailures.Prune(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("got %d deleted; want 2", deleted)
+	}
+
+	f, err := models.LoginFailures.Get(ctx, "ip:192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Failures != 1 || !f.Locked(time.Now()) {
+		t.Errorf("got %+v; want the locked out key kept", f)
+	}
+}
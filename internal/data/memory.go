@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// errForeignKeyViolation is returned by the in-memory stores in the places where
+// PostgreSQL would reject a write because the referenced user doesn't exist.
+var errForeignKeyViolation = errors.New("insert or update violates foreign key constraint")
+
+// memoryDB holds all the state for the in-memory stores. A single mutex guards all
+// "tables" so that operations which span more than one of them (like looking up a user
+// for a token) see a consistent view, just like a single SQL query would.
+type memoryDB struct {
+	mu sync.RWMutex
+	memoryTables
+
+	// txMu makes transactions run one at a time, so that rolling one back can never
+	// undo the writes made by another.
+	txMu sync.Mutex
+}
+
+// memoryTables holds the "tables" of the in-memory database.
+type memoryTables struct {
+	movies      map[int64]*Movie
+	lastMovieID int64
+
+	users      map[int64]*User
+	lastUserID int64
+
+	// tokens is keyed by the string form of the SHA-256 token hash.
+	tokens map[string]*Token
+
+	// permissions is the set of known permission codes, mirroring the rows
+	// seeded into the "permissions" table by the migrations.
+	permissions     map[string]bool
+	userPermissions map[int64]map[string]bool
+
+	// roles is keyed by role ID, and userRoles holds the set of role IDs assigned
+	// to each user.
+	roles      map[int64]*Role
+	lastRoleID int64
+	userRoles  map[int64]map[int64]bool
+
+	emails      map[int64]*Email
+	lastEmailID int64
+
+	// loginFailures is keyed by the same keys as the login_failures table.
+	loginFailures map[string]*LoginFailures
+
+	// mfa is keyed by user ID, and recoveryCodes by the string form of the SHA-256
+	// hash of the code.
+	mfa           map[int64]*MFA
+	recoveryCodes map[string]*memoryRecoveryCode
+
+	apiKeys      map[int64]*APIKey
+	lastAPIKeyID int64
+
+	// identities is keyed by identityKey(issuer, subject), and oidcLogins by the string
+	// form of the SHA-256 hash of the state.
+	identities map[string]*Identity
+	oidcLogins map[string]*OIDCLogin
+
+	// emailChanges holds the new email address of each user with a pending change.
+	emailChanges map[int64]string
+}
+
+// clone returns a copy of the tables which can be restored to roll back a transaction.
+// Stored movies, users, roles, login failures, MFA settings, API keys, identities,
+// OIDC logins and email changes are always replaced rather than modified in place, so
+// only the maps need copying, but tokens and emails are updated in place.
+func (t *memoryTables) clone() memoryTables {
+	c := *t
+	c.movies = maps.Clone(t.movies)
+	c.users = maps.Clone(t.users)
+	c.permissions = maps.Clone(t.permissions)
+	c.roles = maps.Clone(t.roles)
+	c.loginFailures = maps.Clone(t.loginFailures)
+	c.mfa = maps.Clone(t.mfa)
+	c.recoveryCodes = maps.Clone(t.recoveryCodes)
+	c.apiKeys = maps.Clone(t.apiKeys)
+	c.identities = maps.Clone(t.identities)
+	c.oidcLogins = maps.Clone(t.oidcLogins)
+	c.emailChanges = maps.Clone(t.emailChanges)
+
+	c.tokens = make(map[string]*Token, len(t.tokens))
+	for hash, token := range t.tokens {
+		tokenCopy := *token
+		c.tokens[hash] = &tokenCopy
+	}
+
+	c.emails = make(map[int64]*Email, len(t.emails))
+	for id, email := range t.emails {
+		c.emails[id] = copyEmail(email)
+	}
+
+	c.userPermissions = make(map[int64]map[string]bool, len(t.userPermissions))
+	for userID, codes := range t.userPermissions {
+		c.userPermissions[userID] = maps.Clone(codes)
+	}
+
+	c.userRoles = make(map[int64]map[int64]bool, len(t.userRoles))
+	for userID, roleIDs := range t.userRoles {
+		c.userRoles[userID] = maps.Clone(roleIDs)
+	}
+
+	return c
+}
+
+// NewMemoryModels returns a new Models struct backed entirely by memory. It has the
+// same behaviour as the PostgreSQL implementation (including version checks, full-text
+// title matching and pagination), which makes it suitable for tests and local demos.
+// Nothing is persisted, so all data is lost when the process exits.
+func NewMemoryModels() Models {
+	db := &memoryDB{
+		memoryTables: memoryTables{
+			movies:          make(map[int64]*Movie),
+			users:           make(map[int64]*User),
+			tokens:          make(map[string]*Token),
+			permissions:     map[string]bool{"debug:read": true, "movies:read": true, "movies:write": true, "roles:manage": true, "users:manage": true},
+			userPermissions: make(map[int64]map[string]bool),
+			roles:           make(map[int64]*Role),
+			userRoles:       make(map[int64]map[int64]bool),
+			emails:          make(map[int64]*Email),
+			loginFailures:   make(map[string]*LoginFailures),
+			mfa:             make(map[int64]*MFA),
+			recoveryCodes:   make(map[string]*memoryRecoveryCode),
+			apiKeys:         make(map[int64]*APIKey),
+			identities:      make(map[string]*Identity),
+			oidcLogins:      make(map[string]*OIDCLogin),
+			emailChanges:    make(map[int64]string),
+		},
+	}
+
+	// Seed the same roles as the migrations. The admin role has every permission.
+	admin := Permissions{}
+	for code := range db.permissions {
+		admin = append(admin, code)
+	}
+	slices.Sort(admin)
+
+	db.addRole(RoleViewer, Permissions{"movies:read"})
+	db.addRole(RoleEditor, Permissions{"movies:read", "movies:write"})
+	db.addRole(RoleAdmin, admin)
+
+	models := Models{
+		Movies:        memoryMovieStore{db: db},
+		Permissions:   memoryPermissionStore{db: db},
+		Roles:         memoryRoleStore{db: db},
+		Users:         memoryUserStore{db: db},
+		Tokens:        memoryTokenStore{db: db},
+		Outbox:        memoryOutboxStore{db: db},
+		Health:        memoryHealthStore{},
+		LoginFailures: memoryLoginFailureStore{db: db},
+		MFA:           memoryMFAStore{db: db},
+		APIKeys:       memoryAPIKeyStore{db: db},
+		Identities:    memoryIdentityStore{db: db},
+		OIDCLogins:    memoryOIDCLoginStore{db: db},
+		EmailChanges:  memoryEmailChangeStore{db: db},
+	}
+
+	// Inside a transaction the models are the same, but starting another transaction
+	// just joins the current one.
+	inner := models
+	inner.transaction = func(ctx context.Context, fn func(tx Models) error) error {
+		return fn(inner)
+	}
+	models.transaction = func(ctx context.Context, fn func(tx Models) error) error {
+		return db.transaction(func() error { return fn(inner) })
+	}
+
+	return models
+}
+
+// transaction runs fn, restoring all the tables to how they were beforehand if it
+// returns an error. Unlike a real transaction the writes made by fn are visible to other
+// goroutines straight away, and any writes they make while fn is running are lost if
+// it's rolled back, which is good enough for tests and demos.
+func (db *memoryDB) transaction(fn func() error) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	db.mu.RLock()
+	saved := db.memoryTables.clone()
+	db.mu.RUnlock()
+
+	err := fn()
+	if err != nil {
+		db.mu.Lock()
+		db.memoryTables = saved
+		db.mu.Unlock()
+	}
+
+	return err
+}
+
+// now returns the current time truncated to whole seconds, matching the
+// timestamp(0) columns used in the database.
+func (db *memoryDB) now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
@@ -0,0 +1,275 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rynhndrcksn/greenlight/internal/validator"
+)
+
+// RecoveryCodeCount is the number of recovery codes a user gets when they enable
+// two-factor authentication.
+const RecoveryCodeCount = 10
+
+// MFA holds a user's TOTP two-factor authentication settings. The secret is set as soon
+// as the user starts enrolling, but two-factor authentication is only enabled once they
+// confirm it with a code from their authenticator app.
+type MFA struct {
+	UserID       int64
+	Secret       string
+	EnabledAt    *time.Time
+	LastUsedStep int64
+}
+
+// Enabled reports whether the user has finished enrolling.
+func (m *MFA) Enabled() bool {
+	return m.EnabledAt != nil
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount new random recovery codes, which users
+// can log in with instead of a TOTP code if they lose their authenticator app. Each one
+// is 80 random bits, written as four groups of four base32 characters, like
+// "ABCD-EFGH-IJKL-MNOP".
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		s := base32.StdEncoding.EncodeToString(b)
+		codes[i] = s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+	}
+
+	return codes, nil
+}
+
+// ValidateMFACode checks that exactly one of a TOTP code or a recovery code has been
+// provided, and that a TOTP code is six digits long.
+func ValidateMFACode(v *validator.Validator, code, recoveryCode string) {
+	v.Check(code != "" || recoveryCode != "", "code", "must be provided")
+	v.Check(code == "" || recoveryCode == "", "code", "must not be provided with a recovery code")
+
+	if code != "" {
+		v.Check(len(code) == 6 && strings.Trim(code, "0123456789") == "", "code", "must be 6 digits")
+	}
+}
+
+// hashRecoveryCode returns the SHA-256 hash of a recovery code, ignoring case and
+// dashes so users don't have to type the code exactly as it was shown. Like tokens,
+// recovery codes are random enough that a fast hash is fine.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// MFAStore is the interface that wraps the methods for managing users' two-factor
+// authentication settings and recovery codes.
+type MFAStore interface {
+	Get(ctx context.Context, userID int64) (*MFA, error)
+	Begin(ctx context.Context, userID int64, secret string) error
+	Enable(ctx context.Context, userID int64, step int64, recoveryCodes []string) error
+	Disable(ctx context.Context, userID int64) error
+	UseStep(ctx context.Context, userID int64, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, code string) error
+}
+
+// MFAModel struct wraps the connection pool.
+type MFAModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Get returns the user's two-factor authentication settings, or ErrRecordNotFound if
+// they've never started enrolling.
+func (m MFAModel) Get(ctx context.Context, userID int64) (*MFA, error) {
+	query := `
+		SELECT user_id, secret, enabled_at, last_used_step
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var mfa MFA
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&mfa.UserID, &mfa.Secret, &mfa.EnabledAt, &mfa.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &mfa, nil
+}
+
+// Begin starts enrolling the user with a new secret, replacing any earlier enrolment
+// which wasn't confirmed. It returns ErrEditConflict if two-factor authentication is
+// already enabled.
+func (m MFAModel) Begin(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0
+		WHERE user_mfa.enabled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Enable finishes enrolling the user, recording the step of the code they confirmed it
+// with and replacing their recovery codes. It returns ErrEditConflict if the user isn't
+// part way through enrolling.
+func (m MFAModel) Enable(ctx context.Context, userID int64, step int64, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx DBTX) error {
+		query := `
+			UPDATE user_mfa
+			SET enabled_at = NOW(), last_used_step = $2
+			WHERE user_id = $1 AND enabled_at IS NULL`
+
+		result, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrEditConflict
+		}
+
+		query = `
+			DELETE FROM mfa_recovery_codes
+			WHERE user_id = $1`
+
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+
+		hashes := make([][]byte, len(recoveryCodes))
+		for i, code := range recoveryCodes {
+			hashes[i] = hashRecoveryCode(code)
+		}
+
+		query = `
+			INSERT INTO mfa_recovery_codes (hash, user_id)
+			SELECT unnest($2::bytea[]), $1`
+
+		_, err = tx.ExecContext(ctx, query, userID, pq.Array(hashes))
+		return err
+	})
+}
+
+// Disable turns off two-factor authentication for the user, deleting their secret and
+// recovery codes.
+func (m MFAModel) Disable(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// UseStep records that a TOTP code for the step has been used. Codes can't be used for
+// the same step (or an earlier one) again, so it returns ErrEditConflict if the step
+// isn't later than the last one used.
+func (m MFAModel) UseStep(ctx context.Context, userID int64, step int64) error {
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used. It returns
+// ErrRecordNotFound if the user has no unused recovery code matching the one given.
+func (m MFAModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
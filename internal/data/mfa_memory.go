@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// memoryRecoveryCode is a recovery code in the in-memory store.
+type memoryRecoveryCode struct {
+	userID int64
+	usedAt *time.Time
+}
+
+// memoryMFAStore is an in-memory implementation of MFAStore.
+type memoryMFAStore struct {
+	db *memoryDB
+}
+
+// Get returns a copy of the user's two-factor authentication settings.
+func (s memoryMFAStore) Get(ctx context.Context, userID int64) (*MFA, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	mfa, ok := s.db.mfa[userID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	mfaCopy := *mfa
+	return &mfaCopy, nil
+}
+
+// Begin starts enrolling the user with a new secret.
+func (s memoryMFAStore) Begin(ctx context.Context, userID int64, secret string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	if existing, ok := s.db.mfa[userID]; ok && existing.Enabled() {
+		return ErrEditConflict
+	}
+
+	s.db.mfa[userID] = &MFA{UserID: userID, Secret: secret}
+	return nil
+}
+
+// Enable finishes enrolling the user and replaces their recovery codes.
+func (s memoryMFAStore) Enable(ctx context.Context, userID int64, step int64, recoveryCodes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	existing, ok := s.db.mfa[userID]
+	if !ok || existing.Enabled() {
+		return ErrEditConflict
+	}
+
+	// Settings are replaced rather than modified in place, so that rolling back a
+	// transaction only needs a copy of the map.
+	mfa := *existing
+	now := s.db.now()
+	mfa.EnabledAt = &now
+	mfa.LastUsedStep = step
+	s.db.mfa[userID] = &mfa
+
+	s.deleteRecoveryCodes(userID)
+	for _, code := range recoveryCodes {
+		s.db.recoveryCodes[string(hashRecoveryCode(code))] = &memoryRecoveryCode{userID: userID}
+	}
+
+	return nil
+}
+
+// Disable turns off two-factor authentication for the user.
+func (s memoryMFAStore) Disable(ctx context.Context, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.mfa, userID)
+	s.deleteRecoveryCodes(userID)
+	return nil
+}
+
+// UseStep records that a TOTP code for the step has been used.
+func (s memoryMFAStore) UseStep(ctx context.Context, userID int64, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	existing, ok := s.db.mfa[userID]
+	if !ok || existing.LastUsedStep >= step {
+		return ErrEditConflict
+	}
+
+	mfa := *existing
+	mfa.LastUsedStep = step
+	s.db.mfa[userID] = &mfa
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used.
+func (s memoryMFAStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	hash := string(hashRecoveryCode(code))
+
+	existing, ok := s.db.recoveryCodes[hash]
+	if !ok || existing.userID != userID || existing.usedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	now := s.db.now()
+	s.db.recoveryCodes[hash] = &memoryRecoveryCode{userID: userID, usedAt: &now}
+	return nil
+}
+
+// deleteRecoveryCodes deletes all the user's recovery codes. It must be called with the
+// mutex held.
+func (s memoryMFAStore) deleteRecoveryCodes(userID int64) {
+	maps.DeleteFunc(s.db.recoveryCodes, func(_ string, code *memoryRecoveryCode) bool {
+		return code.userID == userID
+	})
+}
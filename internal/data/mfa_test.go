@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// insertMFATestUsers adds n users to the models, with IDs starting from 1.
+func insertMFATestUsers(t *testing.T, models Models, n int) {
+	t.Helper()
+
+	for i := range n {
+		user := &User{Name: "Test User", Email: fmt.Sprintf("user%d@example.com", i+1), Activated: true}
+		user.Password.hash = []byte("hash")
+
+		err := models.Users.Insert(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMFA(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+	insertMFATestUsers(t, models, 1)
+
+	_, err := models.MFA.Get(ctx, 1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got error %v; want %v", err, ErrRecordNotFound)
+	}
+
+	err = models.MFA.Begin(ctx, 1, "SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Starting again before it's enabled just replaces the secret.
+	err = models.MFA.Begin(ctx, 1, "SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.MFA.Enable(ctx, 1, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mfa, err := models.MFA.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mfa.Enabled() || mfa.Secret != "SECRET" || mfa.LastUsedStep != 100 {
+		t.Errorf("got %+v; want enabled with secret %q and last used step 100", mfa, "SECRET")
+	}
+
+	// Once enabled, it can't be started or enabled again without disabling it first.
+	if err := models.MFA.Begin(ctx, 1, "OTHER"); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got error %v from Begin; want %v", err, ErrEditConflict)
+	}
+	if err := models.MFA.Enable(ctx, 1, 101, nil); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got error %v from Enable; want %v", err, ErrEditConflict)
+	}
+
+	// Each step can only be used once, and earlier steps can't be used at all.
+	for _, tt := range []struct {
+		step int64
+		want error
+	}{
+		{100, ErrEditConflict},
+		{99, ErrEditConflict},
+		{101, nil},
+		{101, ErrEditConflict},
+	} {
+		err := models.MFA.UseStep(ctx, 1, tt.step)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("got error %v using step %d; want %v", err, tt.step, tt.want)
+		}
+	}
+
+	err = models.MFA.Disable(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.MFA.Get(ctx, 1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v after disabling; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+	insertMFATestUsers(t, models, 2)
+
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(codes), RecoveryCodeCount)
+	}
+
+	for _, userID := range []int64{1, 2} {
+		err := models.MFA.Begin(ctx, userID, "SECRET")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = models.MFA.Enable(ctx, 1, 0, codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Codes only work for the user they belong to.
+	if err := models.MFA.UseRecoveryCode(ctx, 2, codes[0]); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v for another user's code; want %v", err, ErrRecordNotFound)
+	}
+
+	// Case and dashes don't matter, but each code can only be used once.
+	for _, tt := range []struct {
+		code string
+		want error
+	}{
+		{codes[0], nil},
+		{codes[0], ErrRecordNotFound},
+		{strings.ToLower(strings.ReplaceAll(codes[1], "-", "")), nil},
+		{"AAAA-AAAA-AAAA-AAAA", ErrRecordNotFound},
+	} {
+		err := models.MFA.UseRecoveryCode(ctx, 1, tt.code)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("got error %v using %q; want %v", err, tt.code, tt.want)
+		}
+	}
+
+	// Disabling two-factor authentication throws the remaining codes away.
+	err = models.MFA.Disable(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.MFA.UseRecoveryCode(ctx, 1, codes[2])
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v after disabling; want %v", err, ErrRecordNotFound)
+	}
+}
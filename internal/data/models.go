@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,20 +12,94 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DBTX is the set of methods the PostgreSQL models use to run queries. It's satisfied by
+// both *sql.DB and *sql.Tx, so the same models can work inside or outside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 // Models struct contain the other models our application needs.
+// Each field is an interface, so the PostgreSQL implementations returned by NewModels()
+// can be swapped for the in-memory ones returned by NewMemoryModels().
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Users       UserModel
-	Tokens      TokenModel
+	Movies        MovieStore
+	Permissions   PermissionStore
+	Roles         RoleStore
+	Users         UserStore
+	Tokens        TokenStore
+	Outbox        OutboxStore
+	Health        HealthStore
+	LoginFailures LoginFailureStore
+	MFA           MFAStore
+	APIKeys       APIKeyStore
+	Identities    IdentityStore
+	OIDCLogins    OIDCLoginStore
+	EmailChanges  EmailChangeStore
+
+	// transaction runs fn with a copy of the models which all share one transaction.
+	transaction func(ctx context.Context, fn func(tx Models) error) error
+}
+
+// NewModels returns a new Models struct backed by PostgreSQL. The queryTimeout is the
+// maximum amount of time any single query is allowed to run for.
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
+	return newModels(db, queryTimeout)
 }
 
-// NewModels returns a new Models struct.
-func NewModels(db *sql.DB) Models {
+// newModels returns the PostgreSQL models using db, which is either the connection pool
+// or a transaction.
+func newModels(db DBTX, queryTimeout time.Duration) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Movies:        MovieModel{DB: db, QueryTimeout: queryTimeout},
+		Permissions:   PermissionModel{DB: db, QueryTimeout: queryTimeout},
+		Roles:         RoleModel{DB: db, QueryTimeout: queryTimeout},
+		Users:         UserModel{DB: db, QueryTimeout: queryTimeout},
+		Tokens:        TokenModel{DB: db, QueryTimeout: queryTimeout},
+		Outbox:        OutboxModel{DB: db, QueryTimeout: queryTimeout},
+		Health:        HealthModel{DB: db, QueryTimeout: queryTimeout},
+		LoginFailures: LoginFailureModel{DB: db, QueryTimeout: queryTimeout},
+		MFA:           MFAModel{DB: db, QueryTimeout: queryTimeout},
+		APIKeys:       APIKeyModel{DB: db, QueryTimeout: queryTimeout},
+		Identities:    IdentityModel{DB: db, QueryTimeout: queryTimeout},
+		OIDCLogins:    OIDCLoginModel{DB: db, QueryTimeout: queryTimeout},
+		EmailChanges:  EmailChangeModel{DB: db, QueryTimeout: queryTimeout},
+		transaction: func(ctx context.Context, fn func(tx Models) error) error {
+			return inTx(ctx, db, func(tx DBTX) error {
+				return fn(newModels(tx, queryTimeout))
+			})
+		},
 	}
 }
+
+// Transaction calls fn with a copy of the models where every write is part of a single
+// transaction. The transaction is committed if fn returns nil, and rolled back if it
+// returns an error. Calling Transaction on models which are already in a transaction
+// just calls fn as part of that transaction.
+func (m Models) Transaction(ctx context.Context, fn func(tx Models) error) error {
+	return m.transaction(ctx, fn)
+}
+
+// inTx runs fn inside a new transaction on db, or as part of the existing transaction if
+// db is already one.
+func inTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := pool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/lib/pq"
@@ -43,13 +44,23 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// MovieStore is the interface that wraps the methods for storing and retrieving movies.
+type MovieStore interface {
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+}
+
 // MovieModel struct type which wraps a sql.DB connection pool.
 type MovieModel struct {
-	DB *sql.DB
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // Insert adds a new record in the "movies" table.
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	// Define the SQL query for inserting a new record in the "movies" table and returning
 	// the system-generated data.
 	query := `
@@ -62,8 +73,8 @@ func (m MovieModel) Insert(movie *Movie) error {
 	// make it nice and clear *what values are being used where* in the query.
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context from the caller's context which carries the query timeout.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// Use the QueryRowContext() method to execute the SQL query on our connection pool,
@@ -73,7 +84,7 @@ func (m MovieModel) Insert(movie *Movie) error {
 }
 
 // Get retrieves a movie from the database.
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	// The PostgreSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID values
 	// less than that.
@@ -92,10 +103,11 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
 
-	// Use the context.WithTimeout() function to create a context.Context which carries a
-	// 3-second timeout deadline.
-	// Note that we're using the empty context.Background() as the 'parent' context.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Use the context.WithTimeout() function to create a context.Context which carries
+	// the configured query timeout deadline.
+	// Note that we're using the caller's context as the 'parent' context, so the query is
+	// also cancelled if the client disconnects or the server shuts down.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 
 	// Importantly, use "defer" to make sure that we cancel the context before the Get()
 	// method returns.
@@ -133,7 +145,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 }
 
 // Update updates a movie in the database.
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 	// Declare the SQL query for updating the record and returning the new version number.
 	query := `
         UPDATE movies 
@@ -151,8 +163,8 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context from the caller's context which carries the query timeout.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// Execute the SQL query, and if no matching row could be found, we know the movie
@@ -172,7 +184,7 @@ func (m MovieModel) Update(movie *Movie) error {
 }
 
 // Delete removes a movie from the database.
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
@@ -183,8 +195,8 @@ func (m MovieModel) Delete(id int64) error {
         DELETE FROM movies
         WHERE id = $1`
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context from the caller's context which carries the query timeout.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// Execute the SQL query using the Exec() method, passing in the id variable as
@@ -213,7 +225,12 @@ func (m MovieModel) Delete(id int64) error {
 }
 
 // GetAll retrieves all the movies from the database (as dictated by the Filters).
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	// If the client sent a cursor, use keyset pagination instead.
+	if filters.Cursor != nil {
+		return m.getAllByCursor(ctx, title, genres, filters)
+	}
+
 	// Construct the SQL query to retrieve all movie records.
 	// Thanks to the default values we set for "title" and "genres", we can create
 	// a single SQL query that is flexible enough to allow for dynamic queries.
@@ -230,8 +247,8 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
         ORDER BY %s %s, id ASC
         LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context from the caller's context which carries the query timeout.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// Use QueryContext() to execute the query.
@@ -285,3 +302,78 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 	return movies, metadata, nil
 }
+
+// getAllByCursor retrieves a page of movies using keyset pagination. Rather than
+// skipping over OFFSET rows, the WHERE clause picks up directly from the sort value and
+// ID held in the cursor, which means deep pages are as fast as the first one and rows
+// inserted or deleted concurrently don't cause results to be skipped or repeated.
+// Note that we don't calculate the total number of records here, as that would need
+// to scan every matching row, which is exactly what we're trying to avoid.
+func (m MovieModel) getAllByCursor(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	condition, orderBy := filters.keyset(3, 4)
+
+	// We fetch one more row than the page size so we know whether there's another
+	// page beyond this one.
+	query := fmt.Sprintf(`
+        SELECT id, created_at, title, year, runtime, genres, version
+        FROM movies
+        WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+        AND (genres @> $2 OR $2 = '{}')
+        AND %s
+        ORDER BY %s
+        LIMIT %d`, condition, orderBy, filters.limit()+1)
+
+	args := []any{title, pq.Array(genres)}
+	if filters.Cursor.ID != 0 {
+		args = append(args, filters.cursorValue(), filters.Cursor.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var movies []*Movie
+
+	for rows.Next() {
+		var movie Movie
+
+		err = rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Drop the extra row, if we got one.
+	hasMore := len(movies) > filters.limit()
+	if hasMore {
+		movies = movies[:filters.limit()]
+	}
+
+	// When paging backwards the query returns the rows in reverse, so put them back
+	// into the normal order.
+	if filters.Cursor.Backward {
+		slices.Reverse(movies)
+	}
+
+	metadata := calculateCursorMetadata(movies, hasMore, filters)
+	return movies, metadata, nil
+}
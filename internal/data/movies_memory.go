@@ -0,0 +1,265 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// memoryMovieStore is an in-memory implementation of MovieStore.
+type memoryMovieStore struct {
+	db *memoryDB
+}
+
+// copyMovie returns a deep copy of a movie so callers can never modify the stored
+// record without going through Update().
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	c.Genres = slices.Clone(movie.Genres)
+	return &c
+}
+
+// Insert adds a new movie to the store and sets the system-generated fields.
+func (s memoryMovieStore) Insert(ctx context.Context, movie *Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.lastMovieID++
+	movie.ID = s.db.lastMovieID
+	movie.CreatedAt = s.db.now()
+	movie.Version = 1
+
+	s.db.movies[movie.ID] = copyMovie(movie)
+	return nil
+}
+
+// Get retrieves a movie from the store.
+func (s memoryMovieStore) Get(ctx context.Context, id int64) (*Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	movie, ok := s.db.movies[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+// Update updates a movie in the store, returning ErrEditConflict if the stored
+// version doesn't match the version of the movie being saved.
+func (s memoryMovieStore) Update(ctx context.Context, movie *Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.movies[movie.ID]
+	if !ok || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	updated := copyMovie(movie)
+	updated.CreatedAt = stored.CreatedAt
+	s.db.movies[movie.ID] = updated
+	return nil
+}
+
+// Delete removes a movie from the store.
+func (s memoryMovieStore) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.movies[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(s.db.movies, id)
+	return nil
+}
+
+// GetAll retrieves all the movies matching the title and genres, sorted and paginated
+// as dictated by the Filters.
+func (s memoryMovieStore) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var matches []*Movie
+	for _, movie := range s.db.movies {
+		if matchesTitle(movie.Title, title) && containsAll(movie.Genres, genres) {
+			matches = append(matches, movie)
+		}
+	}
+
+	column := filters.sortColumn()
+	descending := filters.sortDirection() == "DESC"
+
+	// Sort by the requested column and then by ID ascending, just like the
+	// "ORDER BY %s %s, id ASC" clause in the SQL query.
+	order := func(a, b *Movie) int {
+		c := compareMovies(a, b, column)
+		if descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	}
+	slices.SortFunc(matches, order)
+
+	if filters.Cursor != nil {
+		movies, metadata := pageByCursor(matches, filters, order)
+		return movies, metadata, nil
+	}
+
+	totalRecords := len(matches)
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+
+	var movies []*Movie
+	for _, movie := range matches[start:end] {
+		movies = append(movies, copyMovie(movie))
+	}
+
+	// The SQL query reads the total from count(*) OVER(), which is only available
+	// when the page contains at least one row, so we do the same here.
+	if len(movies) == 0 {
+		return nil, Metadata{}, nil
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
+
+// pageByCursor returns the page of sorted movies which comes immediately after (or
+// before, for a backward cursor) the position of the cursor, along with the cursor
+// metadata for the page.
+func pageByCursor(sorted []*Movie, filters Filters, order func(a, b *Movie) int) ([]*Movie, Metadata) {
+	// Build a movie which sits exactly at the cursor's position in the sort order.
+	boundary := &Movie{ID: filters.Cursor.ID}
+	switch value := filters.cursorValue().(type) {
+	case string:
+		boundary.Title = value
+	case int64:
+		boundary.Year = int32(value)
+		boundary.Runtime = Runtime(value)
+	}
+
+	var start, end int
+	var hasMore bool
+
+	switch {
+	case filters.Cursor.Backward:
+		end = len(sorted)
+		if filters.Cursor.ID != 0 {
+			end = indexAtOrAfter(sorted, boundary, order, 0)
+		}
+		start = max(0, end-filters.limit())
+		hasMore = start > 0
+	default:
+		if filters.Cursor.ID != 0 {
+			start = indexAtOrAfter(sorted, boundary, order, 1)
+		}
+		end = min(len(sorted), start+filters.limit())
+		hasMore = end < len(sorted)
+	}
+
+	var movies []*Movie
+	for _, movie := range sorted[start:end] {
+		movies = append(movies, copyMovie(movie))
+	}
+
+	return movies, calculateCursorMetadata(movies, hasMore, filters)
+}
+
+// indexAtOrAfter returns the index of the first movie which compares greater than or
+// equal to (when threshold is 0) or strictly greater than (when threshold is 1) the
+// boundary, or len(sorted) if there isn't one.
+func indexAtOrAfter(sorted []*Movie, boundary *Movie, order func(a, b *Movie) int, threshold int) int {
+	i := slices.IndexFunc(sorted, func(movie *Movie) bool {
+		return order(movie, boundary) >= threshold
+	})
+	if i == -1 {
+		return len(sorted)
+	}
+	return i
+}
+
+// compareMovies compares two movies by the given sort column.
+func compareMovies(a, b *Movie, column string) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return cmp.Compare(a.Year, b.Year)
+	case "runtime":
+		return cmp.Compare(a.Runtime, b.Runtime)
+	default:
+		return cmp.Compare(a.ID, b.ID)
+	}
+}
+
+// matchesTitle mimics to_tsvector('simple', title) @@ plainto_tsquery('simple', query):
+// both strings are split into lowercase words, and every word in the query must
+// appear in the title. An empty query matches everything.
+func matchesTitle(title, query string) bool {
+	if query == "" {
+		return true
+	}
+
+	queryWords := lexemes(query)
+	if len(queryWords) == 0 {
+		return false
+	}
+
+	titleWords := lexemes(title)
+	for _, word := range queryWords {
+		if !slices.Contains(titleWords, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lexemes splits a string into lowercase words, in the same way as the 'simple'
+// text search configuration.
+func lexemes(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsAll mimics the PostgreSQL array containment operator (@>), returning true if
+// every value in want is also present in have.
+func containsAll(have, want []string) bool {
+	for _, value := range want {
+		if !slices.Contains(have, value) {
+			return false
+		}
+	}
+
+	return true
+}
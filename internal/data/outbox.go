@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// The states an email in the outbox can be in. Pending emails are waiting to be sent
+// (or retried), and dead emails have failed too many times to keep trying.
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+// Email is a message waiting to be sent (or which has been sent) from the email outbox.
+// The template data often holds plaintext tokens, so it's only kept while the email is
+// pending.
+type Email struct {
+	ID            int64
+	CreatedAt     time.Time
+	Recipient     string
+	Locale        string
+	Template      string
+	Data          map[string]any
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        *time.Time
+}
+
+// OutboxStore is the interface that wraps the methods for queueing emails and working
+// through the queue.
+type OutboxStore interface {
+	Enqueue(ctx context.Context, email *Email) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*Email, error)
+	Update(ctx context.Context, email *Email) error
+	CountByStatus(ctx context.Context) (map[string]int, error)
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// OutboxModel struct wraps the connection pool.
+type OutboxModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Enqueue adds a new email to the outbox, ready to be sent straight away. When called
+// inside a transaction, the email is only queued if the transaction commits.
+func (m OutboxModel) Enqueue(ctx context.Context, email *Email) error {
+	js, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO email_outbox (recipient, locale, template, data)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, status, attempts, next_attempt_at`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, email.Recipient, email.Locale, email.Template, js).Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+	)
+}
+
+// Claim returns up to limit pending emails which are due to be sent, oldest first. The
+// emails aren't due again until the lease has passed, so other workers won't pick them
+// up in the meantime, but they will be retried if the worker dies before calling
+// Update().
+func (m OutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Email, error) {
+	// SKIP LOCKED lets several workers claim emails at the same time without blocking
+	// each other or claiming the same rows.
+	query := `
+		UPDATE email_outbox
+		SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id
+			FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, recipient, locale, template, data, status, attempts, next_attempt_at, last_error, sent_at`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []*Email
+
+	for rows.Next() {
+		var email Email
+		var js []byte
+
+		err := rows.Scan(
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Locale,
+			&email.Template,
+			&js,
+			&email.Status,
+			&email.Attempts,
+			&email.NextAttemptAt,
+			&email.LastError,
+			&email.SentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(js, &email.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// Update saves the delivery status of an email. Once the email is no longer pending,
+// its template data is cleared so that the tokens in it aren't left in the database.
+func (m OutboxModel) Update(ctx context.Context, email *Email) error {
+	query := `
+		UPDATE email_outbox
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, sent_at = $5,
+			data = CASE WHEN $1 = 'pending' THEN data END
+		WHERE id = $6`
+
+	args := []any{email.Status, email.Attempts, email.NextAttemptAt, email.LastError, email.SentAt, email.ID}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// CountByStatus returns the number of emails in the outbox in each state.
+func (m OutboxModel) CountByStatus(ctx context.Context) (map[string]int, error) {
+	query := `
+		SELECT status, count(*)
+		FROM email_outbox
+		GROUP BY status`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{EmailPending: 0, EmailSent: 0, EmailDead: 0}
+
+	for rows.Next() {
+		var status string
+		var count int
+
+		err := rows.Scan(&status, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// Prune deletes the emails which were queued before the given time and have since been
+// sent or given up on, returning how many were deleted.
+func (m OutboxModel) Prune(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM email_outbox
+		WHERE status <> 'pending' AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
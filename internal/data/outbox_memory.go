@@ -0,0 +1,137 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"time"
+)
+
+// memoryOutboxStore is an in-memory implementation of OutboxStore.
+type memoryOutboxStore struct {
+	db *memoryDB
+}
+
+// copyEmail returns a copy of an email, including its template data.
+func copyEmail(email *Email) *Email {
+	c := *email
+	c.Data = maps.Clone(email.Data)
+	return &c
+}
+
+// Enqueue adds a new email to the outbox, ready to be sent straight away.
+func (s memoryOutboxStore) Enqueue(ctx context.Context, email *Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.lastEmailID++
+	email.ID = s.db.lastEmailID
+	email.CreatedAt = s.db.now()
+	email.Status = EmailPending
+	email.Attempts = 0
+	email.NextAttemptAt = email.CreatedAt
+
+	s.db.emails[email.ID] = copyEmail(email)
+	return nil
+}
+
+// Claim returns up to limit pending emails which are due to be sent, oldest first, and
+// pushes their next attempt back by the lease.
+func (s memoryOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Email, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+
+	var due []*Email
+	for _, email := range s.db.emails {
+		if email.Status == EmailPending && !email.NextAttemptAt.After(now) {
+			due = append(due, email)
+		}
+	}
+	slices.SortFunc(due, func(a, b *Email) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	var emails []*Email
+	for _, email := range due[:min(limit, len(due))] {
+		email.NextAttemptAt = now.Add(lease).Truncate(time.Second)
+		emails = append(emails, copyEmail(email))
+	}
+
+	return emails, nil
+}
+
+// Update saves the delivery status of an email, clearing its template data once it's
+// no longer pending.
+func (s memoryOutboxStore) Update(ctx context.Context, email *Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.emails[email.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	stored.Status = email.Status
+	stored.Attempts = email.Attempts
+	stored.NextAttemptAt = email.NextAttemptAt
+	stored.LastError = email.LastError
+	stored.SentAt = email.SentAt
+	if stored.Status != EmailPending {
+		stored.Data = nil
+	}
+	return nil
+}
+
+// CountByStatus returns the number of emails in the outbox in each state.
+func (s memoryOutboxStore) CountByStatus(ctx context.Context) (map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	counts := map[string]int{EmailPending: 0, EmailSent: 0, EmailDead: 0}
+	for _, email := range s.db.emails {
+		counts[email.Status]++
+	}
+
+	return counts, nil
+}
+
+// Prune deletes the emails which were queued before the given time and have since been
+// sent or given up on.
+func (s memoryOutboxStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var deleted int64
+	maps.DeleteFunc(s.db.emails, func(_ int64, email *Email) bool {
+		if email.Status != EmailPending && email.CreatedAt.Before(before) {
+			deleted++
+			return true
+		}
+		return false
+	})
+
+	return deleted, nil
+}
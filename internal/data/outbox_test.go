@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	assertCounts := func(t *testing.T, pending, sent, dead int) {
+		t.Helper()
+
+		counts, err := models.Outbox.CountByStatus(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if counts[EmailPending] != pending || counts[EmailSent] != sent || counts[EmailDead] != dead {
+			t.Errorf("got counts %v; want %d pending, %d sent and %d dead", counts, pending, sent, dead)
+		}
+	}
+
+	t.Run("Rolled back emails are not queued", func(t *testing.T) {
+		errRollback := errors.New("rollback")
+
+		err := models.Transaction(ctx, func(tx Models) error {
+			err := tx.Outbox.Enqueue(ctx, &Email{Recipient: "alice@example.com", Template: "user_welcome.tmpl"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("got error %v; want %v", err, errRollback)
+		}
+
+		assertCounts(t, 0, 0, 0)
+	})
+
+	t.Run("Claimed emails are leased", func(t *testing.T) {
+		err := models.Transaction(ctx, func(tx Models) error {
+			return tx.Outbox.Enqueue(ctx, &Email{
+				Recipient: "bob@example.com",
+				Template:  "user_welcome.tmpl",
+				Data:      map[string]any{"activationToken": "token"},
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		emails, err := models.Outbox.Claim(ctx, 10, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(emails) != 1 || emails[0].Recipient != "bob@example.com" || emails[0].Data["activationToken"] != "token" {
+			t.Fatalf("got claimed emails %+v; want the queued email", emails)
+		}
+
+		again, err := models.Outbox.Claim(ctx, 10, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(again) != 0 {
+			t.Errorf("claimed %d emails while the lease was held; want 0", len(again))
+		}
+
+		email := emails[0]
+		email.Attempts = 1
+		email.Status = EmailDead
+		email.LastError = "connection refused"
+
+		err = models.Outbox.Update(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertCounts(t, 0, 0, 1)
+
+		// The tokens in the template data aren't kept once the email is finished.
+		if stored := models.Outbox.(memoryOutboxStore).db.emails[email.ID]; stored.Data != nil {
+			t.Errorf("got data %v for a dead email; want it cleared", stored.Data)
+		}
+	})
+
+	t.Run("Finished emails are pruned", func(t *testing.T) {
+		err := models.Outbox.Enqueue(ctx, &Email{Recipient: "carol@example.com", Template: "user_welcome.tmpl"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deleted, err := models.Outbox.Prune(ctx, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 1 {
+			t.Errorf("pruned %d emails; want 1", deleted)
+		}
+
+		assertCounts(t, 1, 0, 0)
+	})
+}
@@ -21,59 +21,134 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// PermissionStore is the interface that wraps the methods for reading and granting permissions.
+type PermissionStore interface {
+	GetAll(ctx context.Context) (Permissions, error)
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, code string) error
+}
+
 // PermissionModel struct is a wrapper around a *sql.DB
 type PermissionModel struct {
-	DB *sql.DB
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// GetAll returns every known permission code, sorted by code.
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPermissions(rows)
 }
 
-// GetAllForUser returns all permission codes for a specific user in a Permissions slice.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser returns all the effective permission codes for a specific user in a
+// Permissions slice, sorted by code. This is the union of the permissions granted to
+// the user directly and the permissions granted by each of their roles.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		ORDER BY code`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+
+	return scanPermissions(rows)
+}
+
+// scanPermissions reads the permission code from each row into a Permissions slice,
+// closing the rows when it's done.
+func scanPermissions(rows *sql.Rows) (Permissions, error) {
 	defer rows.Close()
 
 	var permissions Permissions
 
 	for rows.Next() {
 		var permission string
-		err = rows.Scan(&permission)
+		err := rows.Scan(&permission)
 		if err != nil {
 			return nil, err
 		}
 
 		permissions = append(permissions, permission)
 	}
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
 	return permissions, nil
 }
 
-// AddForUser adds the provided permission code(s) for a specific user.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// AddForUser adds the provided permission code(s) for a specific user. Codes which
+// the user has already been granted are skipped.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	// The SELECT statement creates an 'interim' table with rows made up of the user ID
 	// AND the corresponding IDs for the permissions codes in the array.
 	// The results of this are then inserted into the user_permissions table.
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// RemoveForUser revokes a permission code which was granted directly to a specific
+// user, returning ErrRecordNotFound if the user hadn't been granted it. Permissions
+// granted by the user's roles are unaffected.
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, code string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, code)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"slices"
+)
+
+// memoryPermissionStore is an in-memory implementation of PermissionStore.
+type memoryPermissionStore struct {
+	db *memoryDB
+}
+
+// GetAll returns every known permission code, sorted by code.
+func (s memoryPermissionStore) GetAll(ctx context.Context) (Permissions, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var permissions Permissions
+	for code := range s.db.permissions {
+		permissions = append(permissions, code)
+	}
+	slices.Sort(permissions)
+
+	return permissions, nil
+}
+
+// GetAllForUser returns all the effective permission codes for a specific user, sorted
+// by code. This is the union of the user's direct grants and the grants of their roles.
+func (s memoryPermissionStore) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var permissions Permissions
+	for code := range s.db.userPermissions[userID] {
+		permissions = append(permissions, code)
+	}
+	for roleID := range s.db.userRoles[userID] {
+		permissions = append(permissions, s.db.roles[roleID].Permissions...)
+	}
+	slices.Sort(permissions)
+
+	return slices.Compact(permissions), nil
+}
+
+// AddForUser adds the provided permission code(s) for a specific user. Codes which
+// the user has already been granted are skipped, and unknown codes are ignored, just
+// like the INSERT ... SELECT query in the PostgreSQL implementation.
+func (s memoryPermissionStore) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	granted := s.db.userPermissions[userID]
+	if granted == nil {
+		granted = make(map[string]bool)
+		s.db.userPermissions[userID] = granted
+	}
+	for _, code := range codes {
+		if s.db.permissions[code] {
+			granted[code] = true
+		}
+	}
+
+	return nil
+}
+
+// RemoveForUser revokes a permission code which was granted directly to a specific
+// user, returning ErrRecordNotFound if the user hadn't been granted it.
+func (s memoryPermissionStore) RemoveForUser(ctx context.Context, userID int64, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if !s.db.userPermissions[userID][code] {
+		return ErrRecordNotFound
+	}
+
+	delete(s.db.userPermissions[userID], code)
+	return nil
+}
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rynhndrcksn/greenlight/internal/validator"
+)
+
+// ErrDuplicateRole is returned when creating a role with a name that's already taken.
+var ErrDuplicateRole = errors.New("duplicate role")
+
+// The roles seeded by the migrations. New users are given the viewer role.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Role is a named bundle of permission codes which can be assigned to users.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// ValidateRole runs validation checks against the Role struct. The known slice holds
+// every permission code which can be granted.
+func ValidateRole(v *validator.Validator, role *Role, known Permissions) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 50, "name", "must not be more than 50 bytes long")
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range role.Permissions {
+		v.Check(known.Include(code), "permissions", "must only contain known permission codes")
+	}
+}
+
+// RoleStore is the interface that wraps the methods for managing roles and assigning
+// them to users.
+type RoleStore interface {
+	Insert(ctx context.Context, role *Role) error
+	GetAll(ctx context.Context) ([]*Role, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]string, error)
+	AddForUser(ctx context.Context, userID int64, names ...string) error
+	RemoveForUser(ctx context.Context, userID int64, name string) error
+}
+
+// RoleModel struct wraps the connection pool.
+type RoleModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// Insert adds a new role along with its permissions. Both inserts happen in a single
+// transaction, so we never end up with a role that's missing some of its permissions.
+func (m RoleModel) Insert(ctx context.Context, role *Role) error {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx DBTX) error {
+		query := `
+			INSERT INTO roles (name)
+			VALUES ($1)
+			RETURNING id`
+
+		err := tx.QueryRowContext(ctx, query, role.Name).Scan(&role.ID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+				return ErrDuplicateRole
+			default:
+				return err
+			}
+		}
+
+		query = `
+			INSERT INTO roles_permissions
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, role.ID, pq.StringArray(role.Permissions))
+		return err
+	})
+}
+
+// GetAll returns every role along with its permissions, sorted by name.
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	// The FILTER clause stops roles without any permissions getting an array containing
+	// a single NULL.
+	query := `
+		SELECT roles.id, roles.name, array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL)
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		// pq.Array only recognises plain []string slices, so Permissions needs
+		// converting to scan into it.
+		err := rows.Scan(&role.ID, &role.Name, (*pq.StringArray)(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		if role.Permissions == nil {
+			role.Permissions = Permissions{}
+		}
+
+		roles = append(roles, &role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetAllForUser returns the names of the roles assigned to a specific user, sorted by
+// name.
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// AddForUser assigns the named role(s) to a specific user. Roles which the user already
+// has, and unknown role names, are ignored.
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+// RemoveForUser takes the named role away from a specific user, returning
+// ErrRecordNotFound if the user didn't have it.
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, name string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+		AND users_roles.user_id = $1
+		AND roles.name = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
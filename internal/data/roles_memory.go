@@ -0,0 +1,153 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"slices"
+)
+
+// memoryRoleStore is an in-memory implementation of RoleStore.
+type memoryRoleStore struct {
+	db *memoryDB
+}
+
+// copyRole returns a deep copy of a role.
+func copyRole(role *Role) *Role {
+	c := *role
+	c.Permissions = slices.Clone(role.Permissions)
+	return &c
+}
+
+// addRole stores a new role, keeping only its known permission codes like the
+// INSERT ... SELECT query in the PostgreSQL implementation. The caller must hold the
+// lock.
+func (db *memoryDB) addRole(name string, codes Permissions) *Role {
+	role := &Role{Name: name, Permissions: Permissions{}}
+	for _, code := range codes {
+		if db.permissions[code] {
+			role.Permissions = append(role.Permissions, code)
+		}
+	}
+	slices.Sort(role.Permissions)
+
+	db.lastRoleID++
+	role.ID = db.lastRoleID
+	db.roles[role.ID] = role
+	return role
+}
+
+// findRole returns the role with the given name, or nil if there isn't one. The caller
+// must hold the lock.
+func (db *memoryDB) findRole(name string) *Role {
+	for _, role := range db.roles {
+		if role.Name == name {
+			return role
+		}
+	}
+
+	return nil
+}
+
+// Insert adds a new role along with its permissions, returning ErrDuplicateRole if the
+// name is already taken.
+func (s memoryRoleStore) Insert(ctx context.Context, role *Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.db.findRole(role.Name) != nil {
+		return ErrDuplicateRole
+	}
+
+	role.ID = s.db.addRole(role.Name, role.Permissions).ID
+	return nil
+}
+
+// GetAll returns every role along with its permissions, sorted by name.
+func (s memoryRoleStore) GetAll(ctx context.Context) ([]*Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	roles := []*Role{}
+	for _, role := range s.db.roles {
+		roles = append(roles, copyRole(role))
+	}
+	slices.SortFunc(roles, func(a, b *Role) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return roles, nil
+}
+
+// GetAllForUser returns the names of the roles assigned to a specific user, sorted by
+// name.
+func (s memoryRoleStore) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	names := []string{}
+	for roleID := range s.db.userRoles[userID] {
+		names = append(names, s.db.roles[roleID].Name)
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// AddForUser assigns the named role(s) to a specific user. Roles which the user already
+// has, and unknown role names, are ignored.
+func (s memoryRoleStore) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[userID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	assigned := s.db.userRoles[userID]
+	if assigned == nil {
+		assigned = make(map[int64]bool)
+		s.db.userRoles[userID] = assigned
+	}
+	for _, name := range names {
+		if role := s.db.findRole(name); role != nil {
+			assigned[role.ID] = true
+		}
+	}
+
+	return nil
+}
+
+// RemoveForUser takes the named role away from a specific user, returning
+// ErrRecordNotFound if the user didn't have it.
+func (s memoryRoleStore) RemoveForUser(ctx context.Context, userID int64, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	role := s.db.findRole(name)
+	if role == nil || !s.db.userRoles[userID][role.ID] {
+		return ErrRecordNotFound
+	}
+
+	delete(s.db.userRoles[userID], role.ID)
+	return nil
+}
@@ -0,0 +1,33 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestRoleModelGetAll checks that the roles' permissions are scanned from the
+// aggregated text array, including roles without any.
+func TestRoleModelGetAll(t *testing.T) {
+	db := newFakeDB(t, []string{"id", "name", "array_agg"},
+		[]driver.Value{int64(1), "admin", []byte("{movies:read,movies:write}")},
+		[]driver.Value{int64(2), "empty", nil},
+	)
+
+	roles, err := RoleModel{DB: db, QueryTimeout: time.Second}.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(roles) != 2 {
+		t.Fatalf("got %d roles; want 2", len(roles))
+	}
+	if want := (Permissions{"movies:read", "movies:write"}); !slices.Equal(roles[0].Permissions, want) {
+		t.Errorf("got permissions %v; want %v", roles[0].Permissions, want)
+	}
+	if roles[1].Permissions == nil || len(roles[1].Permissions) != 0 {
+		t.Errorf("got permissions %#v; want an empty slice", roles[1].Permissions)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/rynhndrcksn/greenlight/internal/validator"
@@ -14,15 +15,23 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeRefresh        = "refresh"
+	ScopePasswordReset  = "password-reset"
+	ScopeMFAPending     = "mfa-pending"
+	ScopeEmailChange    = "email-change"
 )
 
 // Token struct contains the data needed for a single token.
+// UsedAt is only set for refresh tokens which have already been exchanged for a new
+// pair of tokens. We keep these around until they expire so that we can detect when
+// one is replayed.
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserId    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext string     `json:"token"`
+	Hash      []byte     `json:"-"`
+	UserId    int64      `json:"-"`
+	Expiry    time.Time  `json:"expiry"`
+	Scope     string     `json:"-"`
+	UsedAt    *time.Time `json:"-"`
 }
 
 // generateToken generates a new token for user activation.
@@ -67,45 +76,145 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
+// TokenStore is the interface that wraps the methods for storing and deleting tokens.
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	Get(ctx context.Context, scope, tokenPlaintext string) (*Token, error)
+	MarkUsed(ctx context.Context, token *Token) error
+	Delete(ctx context.Context, scope, tokenPlaintext string) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
 // TokenModel struct is a wrapper around a sql.DB pointer.
 type TokenModel struct {
-	DB *sql.DB
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // New method is a shortcut that creates a new Token struct and then inserts the
 // data in the "tokens" table.
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
 // Insert adds the data for a specific token to the "tokens" table.
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
         INSERT INTO tokens (hash, user_id, expiry, scope) 
         VALUES ($1, $2, $3, $4)`
 
 	args := []any{token.Hash, token.UserId, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
+// Get retrieves an unexpired token with the given scope, including tokens which have
+// already been used. The plaintext isn't stored in the database, so the Plaintext field
+// of the returned token is set to the plaintext that was passed in.
+func (m TokenModel) Get(ctx context.Context, scope, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        SELECT hash, user_id, expiry, scope, used_at
+        FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	token := Token{Plaintext: tokenPlaintext}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(
+		&token.Hash,
+		&token.UserId,
+		&token.Expiry,
+		&token.Scope,
+		&token.UsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// MarkUsed records that a token has been used. The update only succeeds if the token
+// hasn't been used already, so if two requests race to use the same token only one of
+// them wins and the other gets an ErrEditConflict error.
+func (m TokenModel) MarkUsed(ctx context.Context, token *Token) error {
+	query := `
+        UPDATE tokens
+        SET used_at = NOW()
+        WHERE hash = $1 AND used_at IS NULL
+        RETURNING used_at`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, token.Hash).Scan(&token.UsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a single token, returning ErrRecordNotFound if it doesn't exist.
+func (m TokenModel) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        DELETE FROM tokens
+        WHERE hash = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 // DeleteAllForUser deletes all tokens for a specific user and scope.
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
         DELETE FROM tokens 
         WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
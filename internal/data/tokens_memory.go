@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+)
+
+// memoryTokenStore is an in-memory implementation of TokenStore.
+type memoryTokenStore struct {
+	db *memoryDB
+}
+
+// New creates a new token and adds it to the store.
+func (s memoryTokenStore) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Insert(ctx, token)
+	return token, err
+}
+
+// Insert adds a token to the store. The token's user must already exist.
+func (s memoryTokenStore) Insert(ctx context.Context, token *Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[token.UserId]; !ok {
+		return errForeignKeyViolation
+	}
+
+	// Only the hashed token is persisted in the database, so don't keep the plaintext.
+	stored := *token
+	stored.Plaintext = ""
+	stored.Expiry = token.Expiry.Truncate(time.Second)
+	s.db.tokens[string(token.Hash)] = &stored
+	return nil
+}
+
+// Get retrieves an unexpired token with the given scope, including used tokens.
+func (s memoryTokenStore) Get(ctx context.Context, scope, tokenPlaintext string) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.tokens[string(tokenHash[:])]
+	if !ok || stored.Scope != scope || !stored.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	token := *stored
+	token.Plaintext = tokenPlaintext
+	return &token, nil
+}
+
+// MarkUsed records that a token has been used, returning ErrEditConflict if it
+// has already been used.
+func (s memoryTokenStore) MarkUsed(ctx context.Context, token *Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.tokens[string(token.Hash)]
+	if !ok || stored.UsedAt != nil {
+		return ErrEditConflict
+	}
+
+	usedAt := s.db.now()
+	stored.UsedAt = &usedAt
+	token.UsedAt = &usedAt
+	return nil
+}
+
+// Delete deletes a single token, returning ErrRecordNotFound if it doesn't exist.
+func (s memoryTokenStore) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	token, ok := s.db.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != scope {
+		return ErrRecordNotFound
+	}
+
+	delete(s.db.tokens, string(tokenHash[:]))
+	return nil
+}
+
+// DeleteAllForUser deletes all tokens for a specific user and scope.
+func (s memoryTokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for hash, token := range s.db.tokens {
+		if token.Scope == scope && token.UserId == userID {
+			delete(s.db.tokens, hash)
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,12 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -26,6 +29,14 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
+
+	// DeactivatedAt is when an admin deactivated the user's account, or nil if they
+	// haven't. Deactivated users aren't activated either, but unlike users who have never
+	// activated their account, they can't activate it again themselves.
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+
+	// PreferredLocale is the locale emails are sent to the user in, like "en" or "es".
+	PreferredLocale string `json:"preferred_locale"`
 }
 
 // password struct represents passwords.
@@ -41,6 +52,11 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// Deactivated returns true if an admin has deactivated the user's account.
+func (u *User) Deactivated() bool {
+	return u.DeactivatedAt != nil
+}
+
 // Set calculates the bcrypt hash of a plaintext password and stores
 // both the hash and plaintext versions in the password struct.
 func (p *password) Set(plaintextPassword string) error {
@@ -53,6 +69,26 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// SetRandom sets the password to a random one which nobody knows, for users who log in
+// some other way. They can still set a password of their own with a password reset.
+func (p *password) SetRandom() error {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+
+	err = p.Set(base64.RawURLEncoding.EncodeToString(b))
+	if err != nil {
+		return err
+	}
+
+	// Nobody should ever see the password, so don't keep the plaintext around.
+	p.plaintext = nil
+	return nil
+}
+
 // Matches checks whether the provided plaintext password matches the hashed
 // password stored in the struct.
 // Returns true if they match, otherwise false.
@@ -107,24 +143,37 @@ func ValidateUser(v *validator.Validator, user *User) {
 	}
 }
 
+// UserStore is the interface that wraps the methods for storing and retrieving users.
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	GetForIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	GetAll(ctx context.Context, name, email string, activated *bool, filters Filters) ([]*User, Metadata, error)
+	Delete(ctx context.Context, id int64, version int) error
+}
+
 // UserModel struct wraps the connection pool.
 type UserModel struct {
-	DB *sql.DB
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // Insert a new record in the database for the user. Note that the id, created_at and
 // version fields are all automatically generated by our database, so we use the
 // RETURNING clause to read them into the User struct after the insert, in the same way
 // that we did when creating a movie.
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
-        INSERT INTO users (name, email, password_hash, activated) 
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO users (name, email, password_hash, activated, preferred_locale) 
+        VALUES ($1, $2, $3, $4, $5)
         RETURNING id, created_at, version`
 
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.PreferredLocale}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// If the table already contains a record with this email address, then when we try
@@ -144,18 +193,58 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get retrieves the User details from the database based on the user's ID.
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, deactivated_at, version, preferred_locale
+        FROM users
+        WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.DeactivatedAt,
+		&user.Version,
+		&user.PreferredLocale,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // GetByEmail retrieves the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-        SELECT id, created_at, name, email, password_hash, activated, version
+        SELECT id, created_at, name, email, password_hash, activated, deactivated_at, version, preferred_locale
         FROM users
         WHERE email = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -165,7 +254,9 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.DeactivatedAt,
 		&user.Version,
+		&user.PreferredLocale,
 	)
 
 	if err != nil {
@@ -185,11 +276,11 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 // when updating a movie. And we also check for a violation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
         UPDATE users 
-        SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-        WHERE id = $5 AND version = $6
+        SET name = $1, email = $2, password_hash = $3, activated = $4, deactivated_at = $5, preferred_locale = $6, version = version + 1
+        WHERE id = $7 AND version = $8
         RETURNING version`
 
 	args := []any{
@@ -197,11 +288,13 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.DeactivatedAt,
+		user.PreferredLocale,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -220,20 +313,21 @@ func (m UserModel) Update(user *User) error {
 }
 
 // GetForToken retrieves the user that the provided token belongs to.
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	// Set up the SQL query.
 	query := `
-        SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+        SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.deactivated_at, users.version, users.preferred_locale
         FROM users
         INNER JOIN tokens
         ON users.id = tokens.user_id
         WHERE tokens.hash = $1
         AND tokens.scope = $2 
-        AND tokens.expiry > $3`
+        AND tokens.expiry > $3
+        AND tokens.used_at IS NULL`
 
 	// Create a slice containing the query arguments. Notice how we use the [:] operator
 	// to get a slice containing the token hash, rather than passing in the array (which
@@ -243,7 +337,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct. If no matching
@@ -255,7 +349,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.DeactivatedAt,
 		&user.Version,
+		&user.PreferredLocale,
 	)
 	if err != nil {
 		switch {
@@ -269,3 +365,125 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Return the matching user.
 	return &user, nil
 }
+
+// GetForIdentity retrieves the user that an external identity (the subject of an
+// OpenID Connect provider's ID tokens) is linked to.
+func (m UserModel) GetForIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	query := `
+        SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.deactivated_at, users.version, users.preferred_locale
+        FROM users
+        INNER JOIN user_identities
+        ON users.id = user_identities.user_id
+        WHERE user_identities.issuer = $1
+        AND user_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.DeactivatedAt,
+		&user.Version,
+		&user.PreferredLocale,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAll returns a page of users whose name and email contain the given strings
+// (ignoring case), optionally only returning activated or unactivated users. An empty
+// name or email, or a nil activated, matches every user.
+func (m UserModel) GetAll(ctx context.Context, name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, deactivated_at, version, preferred_locale
+        FROM users
+        WHERE (strpos(lower(name), lower($1)) > 0 OR $1 = '')
+        AND (strpos(lower(email), lower($2)) > 0 OR $2 = '')
+        AND (activated = $3 OR $3 IS NULL)
+        ORDER BY %s %s, id ASC
+        LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	args := []any{name, email, activated, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	var users []*User
+
+	for rows.Next() {
+		var user User
+
+		err = rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.DeactivatedAt,
+			&user.Version,
+			&user.PreferredLocale,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// Delete removes a user (and, thanks to the ON DELETE CASCADE foreign keys, all their
+// tokens and permissions) so long as the version matches, returning ErrEditConflict if
+// it doesn't.
+func (m UserModel) Delete(ctx context.Context, id int64, version int) error {
+	query := `
+        DELETE FROM users
+        WHERE id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
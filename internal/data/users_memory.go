@@ -0,0 +1,270 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// memoryUserStore is an in-memory implementation of UserStore.
+type memoryUserStore struct {
+	db *memoryDB
+}
+
+// copyUser returns a copy of a user. The password hash is never modified in place
+// (password.Set() always assigns a new slice), so a shallow copy is sufficient.
+func copyUser(user *User) *User {
+	c := *user
+	return &c
+}
+
+// findByEmail returns the user with the given email address, comparing emails
+// case-insensitively like the citext column in the database. The caller must hold
+// the lock.
+func (s memoryUserStore) findByEmail(email string) *User {
+	for _, user := range s.db.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// Insert adds a new user to the store, returning ErrDuplicateEmail if the email
+// address is already in use.
+func (s memoryUserStore) Insert(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.findByEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+
+	s.db.lastUserID++
+	user.ID = s.db.lastUserID
+	user.CreatedAt = s.db.now()
+	user.Version = 1
+
+	s.db.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// Get retrieves a user based on their ID.
+func (s memoryUserStore) Get(ctx context.Context, id int64) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user, ok := s.db.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// GetByEmail retrieves a user based on their email address.
+func (s memoryUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user := s.findByEmail(email)
+	if user == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// Update updates a user in the store, checking the version to prevent edit conflicts
+// and the email address to prevent duplicates.
+func (s memoryUserStore) Update(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if existing := s.findByEmail(user.Email); existing != nil && existing.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	updated := copyUser(user)
+	updated.CreatedAt = stored.CreatedAt
+	s.db.users[user.ID] = updated
+	return nil
+}
+
+// GetForToken retrieves the user that the provided, unexpired token belongs to.
+func (s memoryUserStore) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	token, ok := s.db.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) || token.UsedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := s.db.users[token.UserId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// GetForIdentity retrieves the user that an external identity is linked to.
+func (s memoryUserStore) GetForIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	identity, ok := s.db.identities[identityKey(issuer, subject)]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := s.db.users[identity.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// GetAll returns a page of users whose name and email contain the given strings
+// (ignoring case), optionally only returning activated or unactivated users.
+func (s memoryUserStore) GetAll(ctx context.Context, name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var matches []*User
+	for _, user := range s.db.users {
+		if containsFold(user.Name, name) && containsFold(user.Email, email) && (activated == nil || user.Activated == *activated) {
+			matches = append(matches, user)
+		}
+	}
+
+	column := filters.sortColumn()
+	descending := filters.sortDirection() == "DESC"
+
+	slices.SortFunc(matches, func(a, b *User) int {
+		c := compareUsers(a, b, column)
+		if descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	totalRecords := len(matches)
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+
+	var users []*User
+	for _, user := range matches[start:end] {
+		users = append(users, copyUser(user))
+	}
+
+	// Like the SQL query, the total is only known when the page has at least one row.
+	if len(users) == 0 {
+		return nil, Metadata{}, nil
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// Delete removes a user along with their tokens, permissions and roles, so long as
+// the version matches.
+func (s memoryUserStore) Delete(ctx context.Context, id int64, version int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := s.db.users[id]
+	if !ok || user.Version != version {
+		return ErrEditConflict
+	}
+
+	// Mimic the ON DELETE CASCADE foreign keys.
+	for hash, token := range s.db.tokens {
+		if token.UserId == id {
+			delete(s.db.tokens, hash)
+		}
+	}
+	delete(s.db.userPermissions, id)
+	delete(s.db.userRoles, id)
+	delete(s.db.mfa, id)
+	memoryMFAStore{db: s.db}.deleteRecoveryCodes(id)
+	maps.DeleteFunc(s.db.apiKeys, func(_ int64, key *APIKey) bool {
+		return key.UserID == id
+	})
+	maps.DeleteFunc(s.db.identities, func(_ string, identity *Identity) bool {
+		return identity.UserID == id
+	})
+	delete(s.db.emailChanges, id)
+
+	delete(s.db.users, id)
+	return nil
+}
+
+// containsFold reports whether substr is within s, ignoring case.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// compareUsers compares two users by the given sort column.
+func compareUsers(a, b *User, column string) int {
+	switch column {
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "email":
+		return strings.Compare(a.Email, b.Email)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	default:
+		return cmp.Compare(a.ID, b.ID)
+	}
+}
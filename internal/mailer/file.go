@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"os"
+	"time"
+)
+
+// FileSender writes each email to its own .eml file in a directory instead of sending
+// it, which is handy for local development. The files can be opened with most email
+// clients.
+type FileSender struct {
+	dir    string
+	sender string
+}
+
+// NewFileSender returns a FileSender which writes emails to dir, creating it if it
+// doesn't exist.
+func NewFileSender(dir, sender string) (FileSender, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return FileSender{}, err
+	}
+
+	return FileSender{dir: dir, sender: sender}, nil
+}
+
+// Send renders the email and writes it to a new file. The file names start with the
+// time the email was sent, so they're listed in order.
+func (m FileSender) Send(recipient, locale, templateFile string, data any) error {
+	msg, err := newMessage(m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(m.dir, time.Now().UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -3,8 +3,13 @@ package mailer
 import (
 	"bytes"
 	"embed"
-	"html/template"
-	"time"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	texttemplate "text/template"
 
 	"github.com/go-mail/mail/v2"
 )
@@ -18,58 +23,140 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Mailer struct contains a mail.Dialer instance (used to connect to an SMTP server)
-// and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+// DefaultLocale is the locale emails are sent in when a template hasn't been translated
+// into the recipient's locale.
+const DefaultLocale = "en"
+
+// locales holds the locales there are templates for. Each one has its own directory of
+// templates, named after the locale.
+var locales = func() []string {
+	entries, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	var locales []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			locales = append(locales, entry.Name())
+		}
+	}
+
+	return locales
+}()
+
+// Locales returns the locales there are templates for, like "en" and "es".
+func Locales() []string {
+	return slices.Clone(locales)
 }
 
-// New initializes a new Mailer struct and returns it.
-func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-
-	// Return a Mailer instance containing the dialer and sender information.
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+// MatchLocale returns the first of the language tags (like "es" or "en-GB") which there
+// are templates for, ignoring case and falling back to the base language of each tag.
+// If none of them match, it returns DefaultLocale.
+func MatchLocale(tags ...string) string {
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		base, _, _ := strings.Cut(tag, "-")
+
+		for _, locale := range []string{tag, base} {
+			if slices.Contains(locales, locale) {
+				return locale
+			}
+		}
 	}
+
+	return DefaultLocale
 }
 
-// Send takes the recipient email address as the first parameter, the name of the file
-// containing the templates, and any dynamic data for the templates as an any parameter.
-func (m Mailer) Send(recipient, templateFile string, data any) error {
-	// Use the ParseFS() method to parse the required template file from the embedded file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
-	if err != nil {
-		return err
+// Sender is the interface that wraps the Send method, which renders the named template
+// file in the given locale with data and sends the result to recipient. SMTPSender
+// sends emails for real, FileSender writes them to a directory, and MemorySender
+// captures them for tests.
+type Sender interface {
+	Send(recipient, locale, templateFile string, data any) error
+}
+
+// Pinger is implemented by the senders which send emails through another server, and
+// can check that it's reachable.
+type Pinger interface {
+	Ping() error
+}
+
+// templateLocale returns the locale to render the named template file in. If there's
+// no translation for the locale it falls back to its base language, and then to
+// DefaultLocale.
+func templateLocale(locale, templateFile string) string {
+	locale = strings.ToLower(locale)
+	base, _, _ := strings.Cut(locale, "-")
+
+	for _, candidate := range []string{locale, base} {
+		_, err := fs.Stat(templateFS, path.Join("templates", candidate, templateFile))
+		if err == nil {
+			return candidate
+		}
 	}
 
-	// Execute the named template "subject", passing in the dynamic data and storing the
-	// result in a bytes.Buffer variable.
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	return DefaultLocale
+}
+
+// render renders the subject, plain-text body and HTML body of the named template file
+// in the locale with data.
+func render(locale, templateFile string, data any) (subject, plainBody, htmlBody string, err error) {
+	locale = templateLocale(locale, templateFile)
+
+	// Use the ParseFS() method to parse the required template file, along with the shared
+	// layout and the partials for its locale, from the embedded file system. The
+	// partials are always taken from the same locale as the template, so an email is
+	// never sent in a mix of languages.
+	files := []string{
+		"templates/layout.tmpl",
+		path.Join("templates", locale, "partials.tmpl"),
+		path.Join("templates", locale, templateFile),
+	}
+	funcs := map[string]any{"locale": func() string { return locale }}
+
+	// The subject and plain-text body are parsed with text/template, so the data isn't
+	// HTML-escaped in them, and only the HTML body is parsed with html/template.
+	textTmpl, err := texttemplate.New("email").Funcs(funcs).ParseFS(templateFS, files...)
 	if err != nil {
-		return err
+		return "", "", "", err
 	}
 
-	// Follow the same pattern to execute the "plainBody" template and store the result
-	// in the plainBody variable.
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	htmlTmpl, err := htmltemplate.New("email").Funcs(funcs).ParseFS(templateFS, files...)
 	if err != nil {
-		return err
+		return "", "", "", err
 	}
 
-	// And likewise with the "htmlBody" template.
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	// Execute each of the named templates, passing in the dynamic data and storing the
+	// result in a bytes.Buffer variable.
+	var parts [3]string
+	for i, part := range []struct {
+		tmpl interface {
+			ExecuteTemplate(w io.Writer, name string, data any) error
+		}
+		name string
+	}{
+		{textTmpl, "subject"},
+		{textTmpl, "plainBody"},
+		{htmlTmpl, "htmlBody"},
+	} {
+		buf := new(bytes.Buffer)
+		err = part.tmpl.ExecuteTemplate(buf, part.name, data)
+		if err != nil {
+			return "", "", "", err
+		}
+		parts[i] = buf.String()
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+// newMessage renders the named template file in the locale with data, and returns an
+// email message from sender to recipient containing the result.
+func newMessage(sender, recipient, locale, templateFile string, data any) (*mail.Message, error) {
+	subject, plainBody, htmlBody, err := render(locale, templateFile, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
@@ -79,26 +166,10 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	// always be called *after* SetBody().
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-	// error.
-	// Try sending the email three times.
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		// If everything worked, return nil.
-		if nil == err {
-			return nil
-		}
-
-		// If it didn't work, sleep for a short time and retry.
-		time.Sleep(500 * time.Millisecond)
-	}
+	msg.SetHeader("From", sender)
+	msg.SetHeader("Subject", subject)
+	msg.SetBody("text/plain", plainBody)
+	msg.AddAlternative("text/html", htmlBody)
 
-	return nil
+	return msg, nil
 }
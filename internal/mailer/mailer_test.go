@@ -0,0 +1,164 @@
+package mailer
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "emails")
+
+	sender, err := NewFileSender(dir, "Greenlight <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		err = sender.Send("alice@example.com", "en", "token_activation.tmpl", map[string]any{"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files; want 2", len(files))
+	}
+
+	msg, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"To: alice@example.com", "From: Greenlight <no-reply@example.com>", "ABCDEFGHIJKLMNOPQRSTUVWXYZ"} {
+		if !strings.Contains(string(msg), want) {
+			t.Errorf("email does not contain %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestMemorySender(t *testing.T) {
+	sender := NewMemorySender()
+
+	err := sender.Send("alice@example.com", "en", "token_password_reset.tmpl", map[string]any{"passwordResetToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sender.Send("alice@example.com", "en", "missing.tmpl", nil)
+	if err == nil {
+		t.Error("got no error sending a missing template; want an error")
+	}
+
+	errSMTP := errors.New("connection refused")
+	sender.Fail(errSMTP)
+
+	err = sender.Send("bob@example.com", "en", "token_password_reset.tmpl", nil)
+	if !errors.Is(err, errSMTP) {
+		t.Errorf("got error %v; want %v", err, errSMTP)
+	}
+
+	emails := sender.Sent()
+	if len(emails) != 1 {
+		t.Fatalf("got %d emails; want 1", len(emails))
+	}
+	if emails[0].Recipient != "alice@example.com" || !strings.Contains(emails[0].PlainBody, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		t.Errorf("got email %+v; want the password reset email to alice@example.com", emails[0])
+	}
+}
+
+// TestRenderEscaping checks that the data is only HTML-escaped in the HTML body.
+func TestRenderEscaping(t *testing.T) {
+	subject, plainBody, htmlBody, err := render("en", "user_welcome.tmpl", map[string]any{"name": "O'Brien", "activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(subject+plainBody, "&#39;") || !strings.Contains(plainBody, "Hi O'Brien,") {
+		t.Errorf("got plain-text body:\n%s\nwant the name unescaped", plainBody)
+	}
+	if !strings.Contains(htmlBody, "Hi O&#39;Brien,") {
+		t.Errorf("got HTML body:\n%s\nwant the name escaped", htmlBody)
+	}
+}
+
+func TestLocales(t *testing.T) {
+	t.Run("Match", func(t *testing.T) {
+		tests := []struct {
+			tags []string
+			want string
+		}{
+			{nil, DefaultLocale},
+			{[]string{"es"}, "es"},
+			{[]string{"ES-mx"}, "es"},
+			{[]string{"fr", "es"}, "es"},
+			{[]string{"fr"}, DefaultLocale},
+		}
+
+		for _, tt := range tests {
+			if got := MatchLocale(tt.tags...); got != tt.want {
+				t.Errorf("MatchLocale(%q) = %q; want %q", tt.tags, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("Templates", func(t *testing.T) {
+		tests := []struct {
+			locale string
+			want   []string
+		}{
+			{"en", []string{"Welcome to Greenlight!", "Hi Alice,", "The Greenlight Team", `<html lang="en">`}},
+			{"es", []string{"Bienvenido a Greenlight", "Hola, Alice:", "El equipo de Greenlight", `<html lang="es">`}},
+			{"es-MX", []string{"Bienvenido a Greenlight", `<html lang="es">`}},
+			{"fr", []string{"Welcome to Greenlight!", `<html lang="en">`}},
+		}
+
+		for _, tt := range tests {
+			sender := NewMemorySender()
+
+			err := sender.Send("alice@example.com", tt.locale, "user_welcome.tmpl", map[string]any{"name": "Alice", "activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			email := sender.Sent()[0]
+			msg := email.Subject + email.PlainBody + email.HTMLBody
+			for _, want := range tt.want {
+				if !strings.Contains(msg, want) {
+					t.Errorf("%s email does not contain %q:\n%s", tt.locale, want, msg)
+				}
+			}
+		}
+	})
+
+	// Every template should render in every locale, even if it falls back to the default.
+	t.Run("All", func(t *testing.T) {
+		templates, err := fs.Glob(templateFS, "templates/"+DefaultLocale+"/*.tmpl")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sender := NewMemorySender()
+
+		for _, locale := range Locales() {
+			for _, tmpl := range templates {
+				if path.Base(tmpl) == "partials.tmpl" {
+					continue
+				}
+
+				err := sender.Send("alice@example.com", locale, path.Base(tmpl), map[string]any{})
+				if err != nil {
+					t.Errorf("rendering %s in %s: %v", path.Base(tmpl), locale, err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"sync"
+)
+
+// Email is an email captured by a MemorySender.
+type Email struct {
+	Recipient string
+	Locale    string
+	Template  string
+	Data      any
+
+	// The rendered parts of the email.
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// MemorySender captures emails in memory instead of sending them, so that tests can
+// inspect them. The templates are still rendered, so broken templates cause errors just
+// like they would with the other senders.
+type MemorySender struct {
+	mu     sync.Mutex
+	emails []Email
+	err    error
+}
+
+// NewMemorySender returns a new MemorySender.
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+// Send renders the email and captures it.
+func (m *MemorySender) Send(recipient, locale, templateFile string, data any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	subject, plainBody, htmlBody, err := render(locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.emails = append(m.emails, Email{
+		Recipient: recipient,
+		Locale:    locale,
+		Template:  templateFile,
+		Data:      data,
+		Subject:   subject,
+		PlainBody: plainBody,
+		HTMLBody:  htmlBody,
+	})
+	return nil
+}
+
+// Sent returns a copy of all the emails captured so far.
+func (m *MemorySender) Sent() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Email(nil), m.emails...)
+}
+
+// Fail makes every following call to Send() return err, or start capturing emails
+// again if err is nil. It's used to test how failures to send emails are handled.
+func (m *MemorySender) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+}
+
+// Ping returns the error set with Fail(), so tests can simulate an unreachable server.
+func (m *MemorySender) Ping() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// SMTPSender struct contains a mail.Dialer instance (used to connect to an SMTP server)
+// and the sender information for your emails (the name and address you
+// want the email to be from, such as "Alice Smith <alice@example.com>").
+type SMTPSender struct {
+	dialer *mail.Dialer
+	sender string
+}
+
+// NewSMTPSender initializes a new SMTPSender struct and returns it.
+func NewSMTPSender(host string, port int, username, password, sender string) SMTPSender {
+	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
+	// also configure this to use a 5-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	// Return an SMTPSender instance containing the dialer and sender information.
+	return SMTPSender{
+		dialer: dialer,
+		sender: sender,
+	}
+}
+
+// Send takes the recipient email address as the first parameter, the locale to send the
+// email in, the name of the file containing the templates, and any dynamic data for the
+// templates as an any parameter.
+func (m SMTPSender) Send(recipient, locale, templateFile string, data any) error {
+	msg, err := newMessage(m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	// Call the DialAndSend() method on the dialer, passing in the message to send. This
+	// opens a connection to the SMTP server, sends the message, then closes the
+	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
+	// error. Retries are handled by the email outbox, which backs off between attempts,
+	// so we just return the error if this one fails.
+	return m.dialer.DialAndSend(msg)
+}
+
+// Ping checks that the SMTP server can be reached and that it accepts our credentials,
+// by connecting (and authenticating, if there's a username) and then disconnecting.
+func (m SMTPSender) Ping() error {
+	conn, err := m.dialer.Dial()
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
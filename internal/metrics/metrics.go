@@ -0,0 +1,399 @@
+// Package metrics implements the small subset of Prometheus metric types the application
+// needs (counters, gauges and histograms, with or without labels), and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds. They're the same as the
+// ones used by the official Prometheus client, and suit request latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is the interface implemented by every type of metric, which writes the
+// metric's samples in the text exposition format.
+type collector interface {
+	collect(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and serves them to Prometheus.
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a metric to the registry. It panics if a metric with the same name has
+// already been registered, as that's always a programming error.
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %q is already registered", name))
+	}
+
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes every metric in the registry to w in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, c := range collectors {
+		c.collect(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes every metric in the registry to the response.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// countingWriter counts the bytes written to the wrapped writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// desc describes a metric: its name, help text, type and label names.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+// writeHeader writes the HELP and TYPE lines for the metric.
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// writeSample writes a single sample line. The extra label name and value (used for
+// the "le" label of histogram buckets) are ignored if the name is empty.
+func (d desc) writeSample(w *bufio.Writer, name string, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+
+	if len(d.labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escapeLabelValue(values[i]))
+		}
+		if extraName != "" {
+			if len(d.labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// vec holds the series for each combination of label values of a metric.
+type vec[T any] struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+	init   func() *T
+}
+
+// with returns the series for the label values, creating it if it doesn't exist yet.
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %q has %d labels, but got %d values", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = v.init()
+		v.series[key] = s
+		v.values[key] = slices.Clone(values)
+	}
+
+	return s
+}
+
+// each calls fn for every series, sorted by their label values so the output is stable.
+func (v *vec[T]) each(fn func(values []string, s *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mu.Unlock()
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		v.mu.Lock()
+		s, values := v.series[key], v.values[key]
+		v.mu.Unlock()
+
+		fn(values, s)
+	}
+}
+
+func newVec[T any](name, help, typ string, labels []string, init func() *T) *vec[T] {
+	return &vec[T]{
+		desc:   desc{name: name, help: help, typ: typ, labels: labels},
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+		init:   init,
+	}
+}
+
+// Counter is a value which only ever goes up.
+type Counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v, which must not be negative, to the counter.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can't go down")
+	}
+
+	c.mu.Lock()
+	c.v += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec registers and returns a new counter with the given label names. Use
+// no label names for a counter without labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) CounterVec {
+	c := CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(name, c)
+	return c
+}
+
+// With returns the counter for the label values, which must be given in the same order
+// as the label names.
+func (c CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c CounterVec) collect(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, s *Counter) {
+		c.writeSample(w, c.name, values, "", "", s.value())
+	})
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// NewGaugeVec registers and returns a new gauge with the given label names.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) GaugeVec {
+	g := GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.register(name, g)
+	return g
+}
+
+// With returns the gauge for the label values, which must be given in the same order as
+// the label names.
+func (g GaugeVec) With(values ...string) *Gauge {
+	return g.with(values)
+}
+
+func (g GaugeVec) collect(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, s *Gauge) {
+		g.writeSample(w, g.name, values, "", "", s.value())
+	})
+}
+
+// funcMetric is a metric without labels whose value is read by calling a function
+// whenever the metrics are collected.
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+func (f funcMetric) collect(w *bufio.Writer) {
+	f.writeHeader(w)
+	f.writeSample(w, f.name, nil, "", "", f.fn())
+}
+
+// NewGaugeFunc registers a gauge whose value is read by calling fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, funcMetric{desc: desc{name: name, help: help, typ: "gauge"}, fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is read by calling fn. It's useful for
+// exposing counters which are kept elsewhere, like the ones in sql.DBStats.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, funcMetric{desc: desc{name: name, help: help, typ: "counter"}, fn: fn})
+}
+
+// Histogram counts observations (like request durations) in buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec registers and returns a new histogram with the given upper bounds for
+// its buckets (which must be sorted) and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) HistogramVec {
+	buckets = slices.Clone(buckets)
+	h := HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	r.register(name, h)
+	return h
+}
+
+// With returns the histogram for the label values, which must be given in the same
+// order as the label names.
+func (h HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h HistogramVec) collect(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, s *Histogram) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// The bucket counts are cumulative, so each one already includes the
+		// observations in the buckets below it.
+		for i, upper := range s.buckets {
+			h.writeSample(w, h.name+"_bucket", values, "le", formatFloat(upper), float64(s.counts[i]))
+		}
+		h.writeSample(w, h.name+"_bucket", values, "le", "+Inf", float64(s.count))
+		h.writeSample(w, h.name+"_sum", values, "", "", s.sum)
+		h.writeSample(w, h.name+"_count", values, "", "", float64(s.count))
+	})
+}
+
+// formatFloat formats a sample value the way Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// escapeHelp escapes the backslashes and line feeds in help text.
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// escapeLabelValue escapes the backslashes, line feeds and double quotes in a label value.
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounterVec("requests_total", "Requests received.", "method", "path")
+	requests.With("GET", "/b").Inc()
+	requests.With("GET", "/a").Add(2)
+	requests.With("POST", `/"quoted"`+"\n").Inc()
+
+	r.NewGaugeVec("build_info", "Build information.", "version").With("1.0.0").Set(1)
+	r.NewGaugeFunc("open_connections", "Open connections.", func() float64 { return 3 })
+	r.NewCounterFunc("waits_total", "Waits\\for a connection.", func() float64 { return 0.5 })
+
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.With("/a").Observe(0.05)
+	latency.With("/a").Observe(0.5)
+	latency.With("/a").Observe(5)
+
+	rejections := r.NewCounterVec("rejections_total", "Rejected requests.")
+	rejections.With().Inc()
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests received.
+# TYPE requests_total counter
+requests_total{method="GET",path="/a"} 2
+requests_total{method="GET",path="/b"} 1
+requests_total{method="POST",path="/\"quoted\"\n"} 1
+# HELP build_info Build information.
+# TYPE build_info gauge
+build_info{version="1.0.0"} 1
+# HELP open_connections Open connections.
+# TYPE open_connections gauge
+open_connections 3
+# HELP waits_total Waits\\for a connection.
+# TYPE waits_total counter
+waits_total 0.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 5.55
+latency_seconds_count{route="/a"} 3
+# HELP rejections_total Rejected requests.
+# TYPE rejections_total counter
+rejections_total 1
+`
+
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryDuplicateName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name did not panic")
+		}
+	}()
+
+	r := NewRegistry()
+	r.NewCounterVec("requests_total", "Requests received.")
+	r.NewGaugeFunc("requests_total", "Requests received.", func() float64 { return 0 })
+}
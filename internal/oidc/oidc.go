@@ -0,0 +1,469 @@
+// Package oidc implements the parts of OpenID Connect the application needs to let users
+// log in with an external identity provider: discovery, the authorization code flow with
+// PKCE, and verifying the RS256-signed ID tokens the provider returns.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidGrant is returned by Exchange when the provider rejects the
+	// authorization code, because it's invalid, expired, already used, or doesn't
+	// match the code verifier.
+	ErrInvalidGrant = errors.New("oidc: invalid authorization code")
+
+	// ErrInvalidIDToken is returned when the ID token returned by the provider is
+	// malformed, isn't signed by one of the provider's keys, or its claims don't match
+	// what we asked for.
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+)
+
+// keysRefreshInterval is the minimum time between fetching the provider's keys. They're
+// fetched again when an ID token is signed with a key we don't know, which happens when
+// the provider rotates its keys, but we don't want a flood of bad tokens to make us
+// hammer the provider.
+const keysRefreshInterval = time.Minute
+
+// Config holds the settings for a provider.
+type Config struct {
+	// Issuer is the provider's issuer URL, which its discovery document is found under.
+	Issuer string
+	// ClientID and ClientSecret are the credentials the provider issued for us. The
+	// secret is optional for public clients, which rely on PKCE alone.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends users back to after they log in.
+	RedirectURL string
+}
+
+// Claims are the details about the user from a verified ID token.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// metadata holds the parts of the provider's discovery document we use.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider. Its discovery document and keys are fetched
+// the first time they're needed, so the provider doesn't have to be up when the
+// application starts.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *metadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+
+	// keysFetching is closed when the keys currently being fetched (if any) have been
+	// fetched, or the fetch has failed.
+	keysFetching chan struct{}
+}
+
+// New returns a new Provider with the given settings.
+func New(config Config) *Provider {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Issuer returns the provider's issuer URL, which together with the subject of an ID
+// token identifies a user.
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// RandomString returns a new random string, for use as a state, nonce or PKCE code
+// verifier. It's 256 bits, base64url encoded to 43 characters, which is the minimum
+// length RFC 7636 allows for code verifiers.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for a code verifier.
+func CodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// AuthCodeURL returns the URL to send the user to, to log in with the provider. The
+// state and nonce are checked when the user comes back, and the verifier must be passed
+// to Exchange along with the code.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return md.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange exchanges an authorization code for an ID token, and returns the claims from
+// it once it has been verified. The nonce must be the one passed to AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.config.ClientID},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// RFC 6749 says the credentials are form encoded before they're used for basic auth.
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+
+	status, err := p.do(req, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case status == http.StatusBadRequest && body.Error == "invalid_grant":
+		return nil, ErrInvalidGrant
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("oidc: token endpoint returned %d %s", status, body.Error)
+	case body.IDToken == "":
+		return nil, errors.New("oidc: token endpoint didn't return an id token")
+	}
+
+	return p.verify(ctx, body.IDToken, nonce, time.Now())
+}
+
+// idTokenClaims are the claims we use from an ID token. The audience can be a single
+// string or an array of them, and some providers send email_verified as a string.
+type idTokenClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	Expiry        int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	Name          string          `json:"name"`
+}
+
+// verify checks the signature and claims of an ID token at time t, following section
+// 3.1.3.7 of the OpenID Connect Core spec.
+func (p *Provider) verify(ctx context.Context, token, nonce string, t time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if !decodePart(parts[0], &header) || header.Alg != "RS256" {
+		return nil, ErrInvalidIDToken
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims idTokenClaims
+	if !decodePart(parts[1], &claims) {
+		return nil, ErrInvalidIDToken
+	}
+
+	var audience []string
+	if json.Unmarshal(claims.Audience, &audience) != nil {
+		var single string
+		if json.Unmarshal(claims.Audience, &single) != nil {
+			return nil, ErrInvalidIDToken
+		}
+		audience = []string{single}
+	}
+
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, ErrInvalidIDToken
+	case !slices.Contains(audience, p.config.ClientID):
+		return nil, ErrInvalidIDToken
+	case !t.Before(time.Unix(claims.Expiry, 0)):
+		return nil, ErrInvalidIDToken
+	case claims.Nonce != nonce:
+		return nil, ErrInvalidIDToken
+	case claims.Subject == "":
+		return nil, ErrInvalidIDToken
+	}
+
+	return &Claims{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// isTrue reports whether a claim is the boolean true or the string "true". Anything
+// else, including a missing claim, counts as false.
+func isTrue(claim json.RawMessage) bool {
+	var b bool
+	if json.Unmarshal(claim, &b) == nil {
+		return b
+	}
+
+	var s string
+	return json.Unmarshal(claim, &s) == nil && s == "true"
+}
+
+// discover returns the provider's discovery document, fetching it the first time.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var md metadata
+
+	status, err := p.do(req, &md)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("oidc: discovery returned %d", status)
+	case md.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q, not %q", md.Issuer, p.config.Issuer)
+	case md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "":
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// key returns the provider's key with the ID, fetching the provider's keys if we don't
+// have it. The keys are fetched without holding the lock, so logins with a key we
+// already have aren't held up by a slow JWK set endpoint. Only one request fetches
+// them at a time, and any others which need them wait for it to finish.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.mu.Lock()
+
+		if key, ok := p.keys[kid]; ok {
+			p.mu.Unlock()
+			return key, nil
+		}
+
+		fetching := p.keysFetching
+		if fetching == nil {
+			if time.Since(p.keysFetched) < keysRefreshInterval {
+				p.mu.Unlock()
+				return nil, ErrInvalidIDToken
+			}
+
+			p.keysFetching = make(chan struct{})
+			p.mu.Unlock()
+			break
+		}
+
+		p.mu.Unlock()
+
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	keys, err := p.fetchKeys(ctx, md.JWKSURI)
+
+	p.mu.Lock()
+	close(p.keysFetching)
+	p.keysFetching = nil
+	if err == nil {
+		p.keys = keys
+		p.keysFetched = time.Now()
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	return key, nil
+}
+
+// fetchKeys fetches the provider's RSA signing keys from its JWK set, keyed by their
+// IDs. Any other keys are ignored.
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	status, err := p.do(req, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: jwks endpoint returned %d", status)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// do sends the request and decodes the JSON response body into dst, returning the
+// response's status code. Error responses are decoded too, as they hold the OAuth error
+// code.
+func (p *Provider) do(req *http.Request, dst any) (int, error) {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+
+	err = json.Unmarshal(body, dst)
+	if err != nil && res.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("oidc: decoding response from %s: %w", req.URL, err)
+	}
+
+	return res.StatusCode, nil
+}
+
+// decodePart decodes a base64-encoded JSON part of a JWT.
+func decodePart(s string, dst any) bool {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(js, dst) == nil
+}
@@ -0,0 +1,178 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rynhndrcksn/greenlight/internal/oidc"
+	"github.com/rynhndrcksn/greenlight/internal/oidc/oidctest"
+)
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	stub := oidctest.NewProvider(t)
+	identity := oidctest.Identity{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+
+	// login starts a login with the provider and logs in as the identity, returning
+	// the code along with the verifier and nonce used.
+	login := func(t *testing.T, provider *oidc.Provider, identity oidctest.Identity) (code, verifier, nonce string) {
+		t.Helper()
+
+		state, err := oidc.RandomString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce, _ = oidc.RandomString()
+		verifier, _ = oidc.RandomString()
+
+		authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u.Query().Get("redirect_uri"); got != "https://app.example.com/callback" {
+			t.Errorf("got redirect_uri %q; want %q", got, "https://app.example.com/callback")
+		}
+
+		code, gotState, err := stub.Authorize(authURL, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotState != state {
+			t.Errorf("got state %q; want %q", gotState, state)
+		}
+
+		return code, verifier, nonce
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		provider := oidc.New(stub.Config("https://app.example.com/callback"))
+		code, verifier, nonce := login(t, provider, identity)
+
+		claims, err := provider.Exchange(ctx, code, verifier, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := oidc.Claims{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+		if *claims != want {
+			t.Errorf("got claims %+v; want %+v", *claims, want)
+		}
+
+		// Codes can only be exchanged once.
+		_, err = provider.Exchange(ctx, code, verifier, nonce)
+		if !errors.Is(err, oidc.ErrInvalidGrant) {
+			t.Errorf("got error %v exchanging a used code; want %v", err, oidc.ErrInvalidGrant)
+		}
+	})
+
+	t.Run("String email_verified", func(t *testing.T) {
+		provider := oidc.New(stub.Config("https://app.example.com/callback"))
+
+		for _, verified := range []bool{true, false} {
+			identity := identity
+			identity.EmailVerified = verified
+			identity.EmailVerifiedString = true
+
+			code, verifier, nonce := login(t, provider, identity)
+
+			claims, err := provider.Exchange(ctx, code, verifier, nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.EmailVerified != verified {
+				t.Errorf("got EmailVerified %t for %q; want %t", claims.EmailVerified, strconv.FormatBool(verified), verified)
+			}
+		}
+	})
+
+	t.Run("Concurrent logins", func(t *testing.T) {
+		provider := oidc.New(stub.Config("https://app.example.com/callback"))
+		before := stub.KeysFetched()
+
+		// Logins which all need the keys at once should only fetch them once.
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			code, verifier, nonce := login(t, provider, identity)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := provider.Exchange(ctx, code, verifier, nonce)
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got := stub.KeysFetched() - before; got != 1 {
+			t.Errorf("got %d key fetches; want 1", got)
+		}
+	})
+
+	t.Run("Wrong verifier", func(t *testing.T) {
+		provider := oidc.New(stub.Config("https://app.example.com/callback"))
+		code, _, nonce := login(t, provider, identity)
+
+		other, _ := oidc.RandomString()
+		_, err := provider.Exchange(ctx, code, other, nonce)
+		if !errors.Is(err, oidc.ErrInvalidGrant) {
+			t.Errorf("got error %v; want %v", err, oidc.ErrInvalidGrant)
+		}
+	})
+
+	t.Run("Wrong nonce", func(t *testing.T) {
+		provider := oidc.New(stub.Config("https://app.example.com/callback"))
+		code, verifier, _ := login(t, provider, identity)
+
+		other, _ := oidc.RandomString()
+		_, err := provider.Exchange(ctx, code, verifier, other)
+		if !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("got error %v; want %v", err, oidc.ErrInvalidIDToken)
+		}
+	})
+
+	t.Run("Wrong client", func(t *testing.T) {
+		config := stub.Config("https://app.example.com/callback")
+		config.ClientID = "someone-else"
+		provider := oidc.New(config)
+
+		authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := stub.Authorize(authURL, identity); err == nil {
+			t.Error("authorizing an unknown client succeeded; want an error")
+		}
+	})
+
+	t.Run("Wrong issuer", func(t *testing.T) {
+		config := stub.Config("https://app.example.com/callback")
+		config.Issuer = stub.URL + "/other"
+		provider := oidc.New(config)
+
+		_, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+		if err == nil {
+			t.Error("discovery succeeded for the wrong issuer; want an error")
+		}
+	})
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// The example from appendix B of RFC 7636.
+	got := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,240 @@
+// Package oidctest provides a stand-in OpenID Connect provider for testing logins
+// without a real identity provider.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rynhndrcksn/greenlight/internal/oidc"
+)
+
+// Identity is a user of the stand-in provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+
+	// EmailVerifiedString sends email_verified as the string "true" or "false", like
+	// some providers do, instead of a boolean.
+	EmailVerifiedString bool
+}
+
+// grant is an authorization code which hasn't been exchanged yet.
+type grant struct {
+	identity      Identity
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// Provider is a stand-in OpenID Connect provider, serving discovery, token and JWKS
+// endpoints from an httptest.Server. Instead of an authorization endpoint which users
+// log in at, tests call Authorize to log in as any identity.
+type Provider struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+
+	keysFetched atomic.Int64
+}
+
+// keyID is the ID of the provider's only signing key.
+const keyID = "1"
+
+// NewProvider starts a new stand-in provider, which is closed when the test finishes.
+func NewProvider(t testing.TB) *Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Provider{
+		ClientID:     "greenlight",
+		ClientSecret: "client-secret",
+		key:          key,
+		grants:       make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discoveryHandler)
+	mux.HandleFunc("POST /token", p.tokenHandler)
+	mux.HandleFunc("GET /jwks", p.jwksHandler)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// Config returns the settings for an oidc.Provider which uses the stand-in provider.
+func (p *Provider) Config(redirectURL string) oidc.Config {
+	return oidc.Config{
+		Issuer:       p.URL,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// Authorize logs in to the provider as the identity, using an authorization URL from
+// oidc.Provider.AuthCodeURL. It returns the code and state the provider would send the
+// user back to the redirect URL with.
+func (p *Provider) Authorize(authURL string, identity Identity) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := u.Query()
+	switch {
+	case q.Get("response_type") != "code":
+		return "", "", errors.New("oidctest: response_type must be code")
+	case q.Get("client_id") != p.ClientID:
+		return "", "", errors.New("oidctest: unknown client_id")
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		return "", "", errors.New("oidctest: an S256 code_challenge is required")
+	}
+
+	code, err = oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	p.grants[code] = grant{
+		identity:      identity,
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		redirectURI:   q.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	return code, q.Get("state"), nil
+}
+
+func (p *Provider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostFormValue("code")
+
+	// Codes can only be used once, whether or not the exchange succeeds.
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != g.redirectURI ||
+		oidc.CodeChallenge(r.PostFormValue("code_verifier")) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	var emailVerified any = g.identity.EmailVerified
+	if g.identity.EmailVerifiedString {
+		emailVerified = strconv.FormatBool(g.identity.EmailVerified)
+	}
+
+	now := time.Now()
+	idToken := p.sign(map[string]any{
+		"iss":            p.URL,
+		"sub":            g.identity.Subject,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.identity.Email,
+		"email_verified": emailVerified,
+		"name":           g.identity.Name,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// KeysFetched returns the number of times the provider's keys have been fetched.
+func (p *Provider) KeysFetched() int {
+	return int(p.keysFetched.Load())
+}
+
+func (p *Provider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	p.keysFetched.Add(1)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// sign returns an RS256-signed JWT holding the claims.
+func (p *Provider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signingInput))
+
+	// Signing with a valid key can't fail.
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
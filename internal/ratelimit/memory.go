@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// memoryIdleTimeout is the minimum time a client's token bucket is kept after its last
+// request. Buckets are kept for at least their policy's window, by which time they've
+// refilled, so forgetting them makes no difference.
+const memoryIdleTimeout = 5 * time.Minute
+
+// MemoryLimiter is a Limiter which gives each client a token bucket held in memory for
+// each policy. The buckets hold up to the policy's limit, and refill at the rate of the
+// limit per window. They're lost when the process exits, and aren't shared with other
+// instances.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*memoryClient
+	lastSweep time.Time
+
+	// now returns the current time. It's replaced in tests.
+	now func() time.Time
+}
+
+// memoryClient holds the token bucket for a client under one policy.
+type memoryClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	idle     time.Duration
+}
+
+// NewMemoryLimiter returns a new MemoryLimiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		clients: make(map[string]*memoryClient),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the client's bucket for the policy, if there is one.
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, policy Policy) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	// Each policy has its own budget, so its own bucket.
+	key = policy.Name + "\x00" + key
+
+	c, ok := l.clients[key]
+	if !ok {
+		every := policy.Window / time.Duration(policy.Limit)
+		c = &memoryClient{
+			limiter: rate.NewLimiter(rate.Every(every), policy.Limit),
+			idle:    max(memoryIdleTimeout, policy.Window),
+		}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	allowed := c.limiter.AllowN(now, 1)
+	return memoryResult(policy, c.limiter.TokensAt(now), allowed), nil
+}
+
+// Peek checks whether there's a token in the client's bucket for the policy, without
+// taking it. Clients without a bucket have a full one.
+func (l *MemoryLimiter) Peek(ctx context.Context, key string, policy Policy) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tokens := float64(policy.Limit)
+	if c, ok := l.clients[policy.Name+"\x00"+key]; ok {
+		tokens = c.limiter.TokensAt(now)
+	}
+
+	return memoryResult(policy, tokens, tokens >= 1), nil
+}
+
+// memoryResult returns the Result for a bucket holding the given number of tokens.
+func memoryResult(policy Policy, tokens float64, allowed bool) Result {
+	// The time it takes for a single token to be added to the bucket.
+	perToken := float64(policy.Window) / float64(policy.Limit)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     policy.Limit,
+		Remaining: max(int(tokens), 0),
+		Reset:     time.Duration((float64(policy.Limit) - tokens) * perToken),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+
+	return result
+}
+
+// sweep removes the buckets of clients which haven't made a request for a while, at
+// most once a minute. It must be called with the mutex held.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) > c.idle {
+			delete(l.clients, key)
+		}
+	}
+}
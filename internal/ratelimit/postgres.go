@@ -0,0 +1,237 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// PostgresLimiter is a Limiter which keeps count of each client's requests in the
+// rate_limits table, so the limits are shared by every instance using the database and
+// survive restarts.
+//
+// It uses a sliding window: the number of requests a client has made in the last window
+// is estimated from the counts for the current fixed window and the one before it,
+// weighting the previous one by how much of it still overlaps the sliding window. This
+// only needs two rows per client, and smooths out the bursts at the window boundaries
+// which plain fixed windows allow.
+//
+// The windows are based on each instance's clock, so the clocks should be kept in sync.
+type PostgresLimiter struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+
+	// now returns the current time. It's replaced in tests.
+	now func() time.Time
+}
+
+// NewPostgresLimiter returns a new PostgresLimiter. The queryTimeout is the maximum
+// amount of time each query is allowed to run for.
+func NewPostgresLimiter(db *sql.DB, queryTimeout time.Duration) *PostgresLimiter {
+	return &PostgresLimiter{
+		db:           db,
+		queryTimeout: queryTimeout,
+		now:          time.Now,
+	}
+}
+
+// Allow counts the request against the client's current window if the estimated number
+// of requests in the sliding window is still under the policy's limit. Rejected requests
+// aren't counted, so a client which keeps making requests too quickly still gets its
+// share.
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, policy Policy) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.queryTimeout)
+	defer cancel()
+
+	now := l.now()
+	current := now.Truncate(policy.Window)
+
+	// Each policy has its own budget, so its own rows.
+	key = policy.Name + "\x00" + key
+
+	err := l.sweep(ctx, now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	w, err := l.window(ctx, key, policy, now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// If the client has already used up its allowance for the current window, there's no
+	// need to touch the database again.
+	allowed := w.allowed()
+	if w.current >= allowed {
+		return w.result(false), nil
+	}
+
+	// Count the request, unless the client has used up its allowance in the meantime.
+	// The row is locked while it's updated, so concurrent requests from other instances
+	// can't both take the last slot: if the allowance has been used up the WHERE clause
+	// stops the update, nothing is returned and the request is rejected.
+	query := `
+		INSERT INTO rate_limits (key, window_start, count, expires_at)
+		VALUES ($1, $2, 1, $3)
+		ON CONFLICT (key, window_start) DO UPDATE
+		SET count = rate_limits.count + 1
+		WHERE rate_limits.count < $4
+		RETURNING count`
+
+	// The count is needed until the sliding window has moved past the end of the
+	// current window.
+	expires := current.Add(2 * policy.Window)
+
+	err = l.db.QueryRowContext(ctx, query, key, current, expires, allowed).Scan(&w.current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			w.current = allowed
+			return w.result(false), nil
+		default:
+			return Result{}, err
+		}
+	}
+
+	return w.result(true), nil
+}
+
+// Peek checks whether the estimated number of requests in the sliding window is still
+// under the policy's limit, without counting the request.
+func (l *PostgresLimiter) Peek(ctx context.Context, key string, policy Policy) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.queryTimeout)
+	defer cancel()
+
+	w, err := l.window(ctx, policy.Name+"\x00"+key, policy, l.now())
+	if err != nil {
+		return Result{}, err
+	}
+
+	return w.result(w.current < w.allowed()), nil
+}
+
+// window reads the client's counts for the current and previous fixed windows.
+func (l *PostgresLimiter) window(ctx context.Context, key string, policy Policy, now time.Time) (slidingWindow, error) {
+	current := now.Truncate(policy.Window)
+	previous := current.Add(-policy.Window)
+	w := slidingWindow{policy: policy, elapsed: now.Sub(current)}
+
+	query := `
+		SELECT window_start, count
+		FROM rate_limits
+		WHERE key = $1 AND window_start IN ($2, $3)`
+
+	rows, err := l.db.QueryContext(ctx, query, key, previous, current)
+	if err != nil {
+		return slidingWindow{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			start time.Time
+			count int
+		)
+
+		err := rows.Scan(&start, &count)
+		if err != nil {
+			return slidingWindow{}, err
+		}
+
+		if start.Equal(current) {
+			w.current = count
+		} else {
+			w.previous = count
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return slidingWindow{}, err
+	}
+
+	return w, nil
+}
+
+// slidingWindow holds a client's request counts for the current and previous fixed
+// windows, and how far into the current window we are.
+type slidingWindow struct {
+	policy   Policy
+	elapsed  time.Duration
+	previous int
+	current  int
+}
+
+// overlap returns the fraction of the previous window which is still covered by the
+// sliding window after a further wait.
+func (w slidingWindow) overlap(wait time.Duration) float64 {
+	return max(1-float64(w.elapsed+wait)/float64(w.policy.Window), 0)
+}
+
+// allowed returns how many requests the client can make in the current fixed window in
+// total: those which, together with the weighted count for the previous window, come to
+// less than the limit.
+func (w slidingWindow) allowed() int {
+	return int(math.Ceil(float64(w.policy.Limit) - float64(w.previous)*w.overlap(0)))
+}
+
+// result returns the Result for the client's counts.
+func (w slidingWindow) result(allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     w.policy.Limit,
+		Remaining: max(w.allowed()-w.current, 0),
+		// The counts are only forgotten once the sliding window has moved past both
+		// fixed windows, which happens at the end of the next window.
+		Reset: 2*w.policy.Window - w.elapsed,
+	}
+	if w.current == 0 && w.previous == 0 {
+		result.Reset = 0
+	}
+
+	if !allowed {
+		result.RetryAfter = w.retryAfter()
+	}
+
+	return result
+}
+
+// retryAfter returns how long the client must wait until its next request is allowed.
+// That's when enough of the previous window has slid out of the sliding window for
+// there to be room for another request, or failing that, the start of the next window.
+func (w slidingWindow) retryAfter() time.Duration {
+	untilNext := w.policy.Window - w.elapsed
+
+	room := w.policy.Limit - w.current
+	if room <= 0 || w.previous == 0 {
+		return untilNext
+	}
+
+	// Find when previous*overlap drops below room, which is when the overlap drops
+	// below room/previous.
+	wait := time.Duration((1-float64(room)/float64(w.previous))*float64(w.policy.Window)) - w.elapsed
+	return min(max(wait, 0), untilNext)
+}
+
+// sweep deletes the counts which can no longer affect any decision, at most once a
+// minute.
+func (l *PostgresLimiter) sweep(ctx context.Context, now time.Time) error {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) < time.Minute {
+		l.mu.Unlock()
+		return nil
+	}
+	l.lastSweep = now
+	l.mu.Unlock()
+
+	query := `
+		DELETE FROM rate_limits
+		WHERE expires_at < $1`
+
+	_, err := l.db.ExecContext(ctx, query, now)
+	return err
+}
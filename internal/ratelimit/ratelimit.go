@@ -0,0 +1,87 @@
+// Package ratelimit implements the rate limiters used to limit how many requests each
+// client can make. The MemoryLimiter keeps its state in the process, so each instance of
+// the server enforces its own limits, while the PostgresLimiter keeps it in the
+// database, so every instance sharing the database enforces one limit per client.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limiter decides whether the client identified by key can make another request.
+type Limiter interface {
+	// Allow reports whether the request is allowed under the policy, and counts it
+	// against the client's budget for the policy if it is. It only returns an error if
+	// the limiter's state can't be read or updated.
+	Allow(ctx context.Context, key string, policy Policy) (Result, error)
+	// Peek reports whether a request would be allowed under the policy, without
+	// counting it against the client's budget.
+	Peek(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// Policy is a rate limit: each client can make Limit requests per Window. Each policy
+// has its own budget, so requests counted against one policy don't affect another.
+type Policy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// ParsePolicy parses a policy in the form "limit/window", like "10/1m" for 10 requests
+// per minute. The name of the policy is left empty.
+func ParsePolicy(s string) (Policy, error) {
+	limit, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("invalid rate limit policy %q: must be in the form limit/window", s)
+	}
+
+	var (
+		p   Policy
+		err error
+	)
+
+	p.Limit, err = strconv.Atoi(limit)
+	if err != nil || p.Limit < 1 {
+		return Policy{}, fmt.Errorf("invalid rate limit policy %q: limit must be a positive integer", s)
+	}
+
+	p.Window, err = time.ParseDuration(window)
+	if err != nil || p.Window <= 0 {
+		return Policy{}, fmt.Errorf("invalid rate limit policy %q: window must be a positive duration", s)
+	}
+
+	return p, nil
+}
+
+// String returns the policy in the form used by the RateLimit-Policy header, like
+// "10;w=60" for 10 requests per minute.
+func (p Policy) String() string {
+	return fmt.Sprintf("%d;w=%d", p.Limit, Seconds(p.Window))
+}
+
+// Result is the outcome of a call to Allow or Peek.
+type Result struct {
+	// Allowed is true if the request can go ahead.
+	Allowed bool
+	// Limit is the number of requests the policy allows per window.
+	Limit int
+	// Remaining is how many more requests the client can make straight away.
+	Remaining int
+	// Reset is how long it will be until the client's full budget is available again.
+	Reset time.Duration
+	// RetryAfter is how long the client must wait before it can make another request.
+	// It's zero for allowed requests.
+	RetryAfter time.Duration
+}
+
+// Seconds rounds d up to whole seconds, for use in the Retry-After and RateLimit-Reset
+// headers, which only support seconds. Rounding up means clients which wait that long
+// will never be rejected for being too early.
+func Seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,185 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Policy
+		wantErr bool
+	}{
+		{input: "10/1m", want: Policy{Limit: 10, Window: time.Minute}},
+		{input: "5/1h30m", want: Policy{Limit: 5, Window: 90 * time.Minute}},
+		{input: "10", wantErr: true},
+		{input: "0/1m", wantErr: true},
+		{input: "ten/1m", wantErr: true},
+		{input: "10/minute", wantErr: true},
+		{input: "10/500ms", want: Policy{Limit: 10, Window: 500 * time.Millisecond}},
+		{input: "10/0s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePolicy(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error: %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := (Policy{Limit: 10, Window: time.Minute}).String(), "10;w=60"; got != want {
+		t.Errorf("got String() %q; want %q", got, want)
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+
+	// Two requests per second, with bursts of up to four.
+	policy := Policy{Name: "default", Limit: 4, Window: 2 * time.Second}
+
+	allow := func(key string, policy Policy) Result {
+		t.Helper()
+
+		result, err := l.Allow(ctx, key, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// Peeking doesn't use up the budget.
+	for range 5 {
+		result, err := l.Peek(ctx, "alice", policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != 4 {
+			t.Fatalf("got peek %+v; want allowed with 4 remaining", result)
+		}
+	}
+
+	// A client can burst up to the limit...
+	for i := range 4 {
+		result := allow("alice", policy)
+		if !result.Allowed {
+			t.Fatalf("request %d was rejected; want it allowed", i+1)
+		}
+		if result.Remaining != 3-i {
+			t.Errorf("request %d: got %d remaining; want %d", i+1, result.Remaining, 3-i)
+		}
+	}
+
+	// ...but no further.
+	result := allow("alice", policy)
+	if result.Allowed {
+		t.Error("request over the burst was allowed; want it rejected")
+	}
+	if result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("got retry after %s; want 500ms", result.RetryAfter)
+	}
+	if result.Reset != 2*time.Second {
+		t.Errorf("got reset %s; want 2s", result.Reset)
+	}
+
+	result, err := l.Peek(ctx, "alice", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("got peek %+v; want rejected with retry after 500ms", result)
+	}
+
+	// Other clients have their own buckets, and so do other policies.
+	if !allow("bob", policy).Allowed {
+		t.Error("another client's request was rejected; want it allowed")
+	}
+	if !allow("alice", Policy{Name: "login", Limit: 1, Window: time.Minute}).Allowed {
+		t.Error("request under another policy was rejected; want it allowed")
+	}
+
+	// The bucket refills at the given rate.
+	now = now.Add(500 * time.Millisecond)
+	if !allow("alice", policy).Allowed {
+		t.Error("request after the bucket refilled was rejected; want it allowed")
+	}
+	if allow("alice", policy).Allowed {
+		t.Error("second request after one token refilled was allowed; want it rejected")
+	}
+
+	// Idle clients are forgotten.
+	now = now.Add(memoryIdleTimeout + time.Minute)
+	allow("bob", policy)
+
+	l.mu.Lock()
+	_, found := l.clients["default\x00alice"]
+	l.mu.Unlock()
+
+	if found {
+		t.Error("idle client was not forgotten")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	policy := Policy{Limit: 10, Window: time.Minute}
+
+	tests := []struct {
+		name           string
+		window         slidingWindow
+		wantAllowed    int
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:           "No previous requests",
+			window:         slidingWindow{policy: policy, current: 10},
+			wantAllowed:    10,
+			wantRetryAfter: time.Minute,
+		},
+		{
+			name:           "Start of the window",
+			window:         slidingWindow{policy: policy, previous: 20},
+			wantAllowed:    -10,
+			wantRetryAfter: 30 * time.Second,
+		},
+		{
+			name:           "Part way through the window",
+			window:         slidingWindow{policy: policy, elapsed: 20 * time.Second, previous: 10, current: 5},
+			wantAllowed:    4,
+			wantRetryAfter: 10 * time.Second,
+		},
+		{
+			name:           "Partial requests round up",
+			window:         slidingWindow{policy: policy, elapsed: 15 * time.Second, previous: 5, current: 7},
+			wantAllowed:    7,
+			wantRetryAfter: 9 * time.Second,
+		},
+		{
+			name:           "Previous window over the limit",
+			window:         slidingWindow{policy: policy, previous: 30},
+			wantAllowed:    -20,
+			wantRetryAfter: 40 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.allowed(); got != tt.wantAllowed {
+				t.Errorf("got %d allowed; want %d", got, tt.wantAllowed)
+			}
+			if got := tt.window.retryAfter(); got != tt.wantRetryAfter {
+				t.Errorf("got retry after %s; want %s", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
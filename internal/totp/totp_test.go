@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret from the test vectors in RFC 6238, base32 encoded.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// The RFC's test vectors are eight digits long, so these are their last six.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got code %s at %d; want %s", got, tt.unix, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := Validate(rfcSecret, code, now)
+	if !ok || step != Step(now) {
+		t.Errorf("got step %d, %t; want %d, true", step, ok, Step(now))
+	}
+
+	// Codes from the neighbouring periods are accepted, but no further.
+	if _, ok := Validate(rfcSecret, code, now.Add(Period)); !ok {
+		t.Error("code from the previous period was rejected")
+	}
+	if _, ok := Validate(rfcSecret, code, now.Add(2*Period)); ok {
+		t.Error("code from two periods ago was accepted")
+	}
+
+	for _, input := range []string{"", "12345", "1234567", "000000"} {
+		if _, ok := Validate(rfcSecret, input, now); ok {
+			t.Errorf("invalid code %q was accepted", input)
+		}
+	}
+
+	if _, ok := Validate("not base32!", code, now); ok {
+		t.Error("code was accepted for an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("got a %d byte secret; want 20 bytes", len(key))
+	}
+}
+
+func TestURL(t *testing.T) {
+	u, err := url.Parse(URL("Greenlight", "alice@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Greenlight:alice@example.com" {
+		t.Errorf("got %s; want an otpauth://totp/Greenlight:alice@example.com URL", u)
+	}
+
+	q := u.Query()
+	for key, want := range map[string]string{"secret": "JBSWY3DPEHPK3PXP", "issuer": "Greenlight", "digits": "6", "period": "30"} {
+		if got := q.Get(key); got != want {
+			t.Errorf("got %s %q; want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+-- Add the permission needed to manage users, and grant it to the admin role.
+INSERT INTO permissions (code)
+VALUES ('users:manage');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+  AND permissions.code = 'users:manage';
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS email_outbox
+(
+    id              bigserial PRIMARY KEY,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    recipient       text                        NOT NULL,
+    template        text                        NOT NULL,
+    data            jsonb                       NOT NULL,
+    status          text                        NOT NULL DEFAULT 'pending',
+    attempts        integer                     NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_error      text                        NOT NULL DEFAULT '',
+    sent_at         timestamp(0) with time zone
+);
+
+-- The worker only ever looks for pending emails which are due, so only index those.
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
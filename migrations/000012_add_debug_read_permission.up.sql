@@ -0,0 +1,11 @@
+-- Add the permission needed to read the ops endpoints (metrics, expvar and pprof) on the
+-- API port, and grant it to the admin role.
+INSERT INTO permissions (code)
+VALUES ('debug:read');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+  AND permissions.code = 'debug:read';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS rate_limits
+(
+    key          text                     NOT NULL,
+    window_start timestamp with time zone NOT NULL,
+    count        integer                  NOT NULL DEFAULT 0,
+    expires_at   timestamp with time zone NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+-- Policies can have windows of different lengths, so each count records when it can be
+-- deleted, and old counts are deleted in bulk by that time.
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
@@ -0,0 +1,11 @@
+-- Recent failed login attempts, keyed by account (email address) or client IP address.
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    key             text PRIMARY KEY,
+    failures        integer                  NOT NULL DEFAULT 0,
+    last_failure_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    locked_until    timestamp with time zone
+);
+
+-- Old failures are deleted in bulk, so index them by time.
+CREATE INDEX IF NOT EXISTS login_failures_last_failure_at_idx ON login_failures (last_failure_at);
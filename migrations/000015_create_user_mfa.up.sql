@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_mfa
+(
+    user_id        bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret         text                        NOT NULL,
+    enabled_at     timestamp(0) with time zone,
+    last_used_step bigint                      NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes
+(
+    hash    bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS email_outbox_finished_idx;
+
+UPDATE email_outbox SET data = '{}' WHERE data IS NULL;
+
+ALTER TABLE email_outbox ALTER COLUMN data SET NOT NULL;
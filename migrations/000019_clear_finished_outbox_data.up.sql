@@ -0,0 +1,8 @@
+-- The template data holds plaintext tokens, so it's cleared once an email has been sent
+-- or given up on.
+ALTER TABLE email_outbox ALTER COLUMN data DROP NOT NULL;
+
+UPDATE email_outbox SET data = NULL WHERE status <> 'pending';
+
+-- Finished emails are pruned by age.
+CREATE INDEX IF NOT EXISTS email_outbox_finished_idx ON email_outbox (created_at) WHERE status <> 'pending';
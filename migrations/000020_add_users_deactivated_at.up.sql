@@ -0,0 +1,3 @@
+-- Accounts deactivated by an admin are told apart from ones which were never activated,
+-- so that their owners can't activate them again.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deactivated_at timestamp with time zone;